@@ -48,7 +48,7 @@ func SetNewCarStatus(s storage.Storage, key storage.ObjectKey) error {
 	car.Status.Distance = rand.Uint64()
 	car.Status.Speed = rand.Float64() * 100
 
-	return s.Update(car)
+	return s.UpdateStatus(car)
 }
 
 func ParseVersionFlag() {
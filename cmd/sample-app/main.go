@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"github.com/labstack/echo"
+	"github.com/save-abandoned-projects/libgitops/api/openapi"
 	"github.com/save-abandoned-projects/libgitops/cmd/common"
 	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/scheme"
 	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/v1alpha1"
@@ -44,6 +45,7 @@ func run() error {
 		storage.NewGenericRawStorage(*manifestDirFlag, v1alpha1.SchemeGroupVersion, serializer.ContentTypeYAML),
 		scheme.Serializer,
 		[]runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+		storage.WithSchemaValidation(serializer.NewSchemaValidator(scheme.Scheme, openapi.GetOpenAPIDefinitions)),
 	)
 	defer func() { _ = plainStorage.Close() }()
 
@@ -0,0 +1,295 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// FieldOperator describes how the field selected by a FieldFilter is compared to its Values.
+type FieldOperator string
+
+const (
+	// FieldOpEquals matches if the field equals the only value.
+	FieldOpEquals FieldOperator = "=="
+	// FieldOpNotEquals matches if the field doesn't equal the only value.
+	FieldOpNotEquals FieldOperator = "!="
+	// FieldOpIn matches if the field equals any of the values.
+	FieldOpIn FieldOperator = "in"
+	// FieldOpNotIn matches if the field equals none of the values.
+	FieldOpNotIn FieldOperator = "notin"
+	// FieldOpGreaterThan matches if the field is numerically greater than the only value.
+	FieldOpGreaterThan FieldOperator = ">"
+	// FieldOpGreaterThanOrEqual matches if the field is numerically greater than or equal to the only value.
+	FieldOpGreaterThanOrEqual FieldOperator = ">="
+	// FieldOpLessThan matches if the field is numerically less than the only value.
+	FieldOpLessThan FieldOperator = "<"
+	// FieldOpLessThanOrEqual matches if the field is numerically less than or equal to the only value.
+	FieldOpLessThanOrEqual FieldOperator = "<="
+)
+
+// FieldFilter implements ObjectFilter and ListOption.
+var _ ObjectFilter = FieldFilter{}
+var _ ListOption = FieldFilter{}
+
+// FieldFilter is an ObjectFilter that evaluates a JSONPath expression against the
+// decoded object, and compares the result to Values using Operator. If the expression
+// yields multiple results (e.g. "{.spec.items[*].name}"), the object matches if any of
+// them matches; for FieldOpNotEquals and FieldOpNotIn, all of them must match. A missing
+// field only matches FieldOpNotEquals and FieldOpNotIn.
+type FieldFilter struct {
+	// Path is a JSONPath expression selecting the field to compare, e.g. "{.spec.brand}".
+	// The curly braces and the leading dot may be omitted, i.e. "status.speed" works as well.
+	// Only the child (".name" and "['name']") and index ("[0]" and "[*]") operators of JSONPath
+	// are supported. Names containing dots must be quoted, e.g. "metadata.labels['app.kubernetes.io/name']".
+	// +required
+	Path string
+	// Operator specifies how the selected field is compared to Values.
+	// +required
+	Operator FieldOperator
+	// Values to compare the selected field against. FieldOpIn and FieldOpNotIn accept any
+	// number of values, all other operators require exactly one.
+	// +required
+	Values []string
+}
+
+// Filter implements ObjectFilter
+func (f FieldFilter) Filter(obj runtime.Object) (bool, error) {
+	fp, err := f.parse()
+	if err != nil {
+		return false, err
+	}
+
+	return fieldFilter{f, fp}.Filter(obj)
+}
+
+// ApplyToListOptions implements ListOption, and adds itself converted to
+// a ListFilter to ListOptions.Filters.
+func (f FieldFilter) ApplyToListOptions(target *ListOptions) error {
+	// Parse the expression only once, this also validates the parameters early
+	fp, err := f.parse()
+	if err != nil {
+		return err
+	}
+
+	target.Filters = append(target.Filters, ObjectToListFilter(fieldFilter{f, fp}))
+	return nil
+}
+
+// parse validates the FieldFilter, and parses its JSONPath expression.
+func (f FieldFilter) parse() (fieldPath, error) {
+	if len(f.Path) == 0 {
+		return nil, fmt.Errorf("the FieldFilter.Path field must not be empty: %w", ErrInvalidFilterParams)
+	}
+
+	switch f.Operator {
+	case FieldOpIn, FieldOpNotIn:
+		if len(f.Values) == 0 {
+			return nil, fmt.Errorf("the FieldFilter.Values field must not be empty for operator %q: %w", f.Operator, ErrInvalidFilterParams)
+		}
+	case FieldOpEquals, FieldOpNotEquals:
+		if len(f.Values) != 1 {
+			return nil, fmt.Errorf("the FieldFilter.Values field must have exactly one value for operator %q: %w", f.Operator, ErrInvalidFilterParams)
+		}
+	case FieldOpGreaterThan, FieldOpGreaterThanOrEqual, FieldOpLessThan, FieldOpLessThanOrEqual:
+		if len(f.Values) != 1 {
+			return nil, fmt.Errorf("the FieldFilter.Values field must have exactly one value for operator %q: %w", f.Operator, ErrInvalidFilterParams)
+		}
+		if _, err := strconv.ParseFloat(f.Values[0], 64); err != nil {
+			return nil, fmt.Errorf("the FieldFilter.Values field must be numeric for operator %q: %v: %w", f.Operator, err, ErrInvalidFilterParams)
+		}
+	default:
+		return nil, fmt.Errorf("unknown FieldFilter.Operator %q: %w", f.Operator, ErrInvalidFilterParams)
+	}
+
+	fp, err := parseFieldPath(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FieldFilter.Path %q: %v: %w", f.Path, err, ErrInvalidFilterParams)
+	}
+	return fp, nil
+}
+
+// fieldPathStep is one step of a fieldPath. It selects either the field with the given
+// name of a map, or the element at the given index of a list, or all elements if all is set.
+type fieldPathStep struct {
+	name  string
+	index int
+	all   bool
+}
+
+// fieldPath is a parsed JSONPath expression, consisting of child and index operators only.
+type fieldPath []fieldPathStep
+
+// parseFieldPath parses expressions of the form "{.a['b.c'].d[0].e[*]}". The curly braces
+// and the leading dot are optional.
+func parseFieldPath(path string) (fieldPath, error) {
+	if strings.HasPrefix(path, "{") {
+		if !strings.HasSuffix(path, "}") {
+			return nil, fmt.Errorf("unclosed curly brace")
+		}
+		path = path[1 : len(path)-1]
+	}
+	if !strings.HasPrefix(path, ".") && !strings.HasPrefix(path, "[") {
+		path = "." + path
+	}
+
+	var fp fieldPath
+	for len(path) != 0 {
+		switch {
+		case path[0] == '.':
+			// Child operator, e.g. ".name"
+			end := strings.IndexAny(path[1:], ".[") + 1
+			if end == 0 {
+				end = len(path)
+			}
+			name := path[1:end]
+			if len(name) == 0 || strings.ContainsAny(name, "{}[]()@$?*'\"\\ ") {
+				return nil, fmt.Errorf("invalid field name %q", name)
+			}
+			fp = append(fp, fieldPathStep{name: name})
+			path = path[end:]
+		case strings.HasPrefix(path, "['") || strings.HasPrefix(path, `["`):
+			// Quoted child operator, e.g. "['app.kubernetes.io/name']", the name may contain
+			// any character but the quote
+			end := strings.IndexByte(path[2:], path[1]) + 2
+			if end < 2 || !strings.HasPrefix(path[end+1:], "]") {
+				return nil, fmt.Errorf("unclosed quoted field name in %q", path)
+			}
+			name := path[2:end]
+			if len(name) == 0 {
+				return nil, fmt.Errorf("empty field name in %q", path)
+			}
+			fp = append(fp, fieldPathStep{name: name})
+			path = path[end+2:]
+		case path[0] == '[':
+			// Index operator, e.g. "[0]" or "[*]"
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed index operator in %q", path)
+			}
+			index := path[1:end]
+			path = path[end+1:]
+			if index == "*" {
+				fp = append(fp, fieldPathStep{all: true})
+				continue
+			}
+			i, err := strconv.Atoi(index)
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("invalid index %q", index)
+			}
+			fp = append(fp, fieldPathStep{index: i})
+		default:
+			return nil, fmt.Errorf("unexpected %q, expected a child or index operator", path)
+		}
+	}
+	return fp, nil
+}
+
+// find returns the values selected by the path in the given JSON object.
+// Missing fields and out-of-range indexes select nothing.
+func (fp fieldPath) find(obj map[string]interface{}) []interface{} {
+	values := []interface{}{obj}
+	for _, step := range fp {
+		var next []interface{}
+		for _, value := range values {
+			if len(step.name) != 0 {
+				if m, ok := value.(map[string]interface{}); ok {
+					if field, ok := m[step.name]; ok {
+						next = append(next, field)
+					}
+				}
+				continue
+			}
+
+			list, ok := value.([]interface{})
+			if !ok {
+				continue
+			}
+			if step.all {
+				next = append(next, list...)
+			} else if step.index < len(list) {
+				next = append(next, list[step.index])
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// fieldFilter is an ObjectFilter for a FieldFilter with an already parsed JSONPath expression.
+type fieldFilter struct {
+	FieldFilter
+	fp fieldPath
+}
+
+// Filter implements ObjectFilter
+func (f fieldFilter) Filter(obj runtime.Object) (bool, error) {
+	// Evaluate the expression against the JSON representation of the object
+	u, err := kruntime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return false, err
+	}
+
+	var fields []string
+	for _, value := range f.fp.find(u) {
+		fields = append(fields, fmt.Sprint(value))
+	}
+
+	// The negated operators must hold for all fields, the others for any field
+	switch f.Operator {
+	case FieldOpNotEquals, FieldOpNotIn:
+		for _, field := range fields {
+			if f.containsValue(field) {
+				return false, nil
+			}
+		}
+		return true, nil
+	default:
+		for _, field := range fields {
+			if f.matches(field) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// matches compares one field to the values using a non-negated operator.
+func (f fieldFilter) matches(field string) bool {
+	switch f.Operator {
+	case FieldOpEquals, FieldOpIn:
+		return f.containsValue(field)
+	}
+
+	// The value was validated to be numeric in parse(), non-numeric fields never match
+	num, err := strconv.ParseFloat(field, 64)
+	if err != nil {
+		return false
+	}
+	value, _ := strconv.ParseFloat(f.Values[0], 64)
+
+	switch f.Operator {
+	case FieldOpGreaterThan:
+		return num > value
+	case FieldOpGreaterThanOrEqual:
+		return num >= value
+	case FieldOpLessThan:
+		return num < value
+	case FieldOpLessThanOrEqual:
+		return num <= value
+	}
+	return false
+}
+
+// containsValue returns true if field equals any of the values.
+func (f fieldFilter) containsValue(field string) bool {
+	for _, value := range f.Values {
+		if field == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package filter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newCar(name, brand string, speed float64) runtime.Object {
+	car := &v1alpha1.Car{}
+	car.Name = name
+	car.Spec.Brand = brand
+	car.Status.Speed = speed
+	return car
+}
+
+func TestFieldFilter(t *testing.T) {
+	objs := []runtime.Object{
+		newCar("a", "Volvo", 30),
+		newCar("b", "Saab", 50),
+		newCar("c", "Volvo", 80.5),
+	}
+	objs[1].SetOwnerReferences([]metav1.OwnerReference{{Name: "x"}, {Name: "y"}})
+	objs[2].SetLabels(map[string]string{"app.kubernetes.io/name": "racer"})
+
+	tests := []struct {
+		name    string
+		filter  FieldFilter
+		want    []string
+		wantErr error
+	}{
+		{
+			name:   "equals",
+			filter: FieldFilter{Path: "{.spec.brand}", Operator: FieldOpEquals, Values: []string{"Volvo"}},
+			want:   []string{"a", "c"},
+		},
+		{
+			name:   "not equals without braces",
+			filter: FieldFilter{Path: "spec.brand", Operator: FieldOpNotEquals, Values: []string{"Volvo"}},
+			want:   []string{"b"},
+		},
+		{
+			name:   "in",
+			filter: FieldFilter{Path: ".metadata.name", Operator: FieldOpIn, Values: []string{"a", "b"}},
+			want:   []string{"a", "b"},
+		},
+		{
+			name:   "not in",
+			filter: FieldFilter{Path: ".metadata.name", Operator: FieldOpNotIn, Values: []string{"a", "b"}},
+			want:   []string{"c"},
+		},
+		{
+			name:   "greater than",
+			filter: FieldFilter{Path: "status.speed", Operator: FieldOpGreaterThan, Values: []string{"50"}},
+			want:   []string{"c"},
+		},
+		{
+			name:   "less than or equal",
+			filter: FieldFilter{Path: "status.speed", Operator: FieldOpLessThanOrEqual, Values: []string{"50"}},
+			want:   []string{"a", "b"},
+		},
+		{
+			name:   "missing field",
+			filter: FieldFilter{Path: "spec.color", Operator: FieldOpEquals, Values: []string{"red"}},
+			want:   []string{},
+		},
+		{
+			name:   "any list element",
+			filter: FieldFilter{Path: "{.metadata.ownerReferences[*].name}", Operator: FieldOpEquals, Values: []string{"y"}},
+			want:   []string{"b"},
+		},
+		{
+			name:   "list index",
+			filter: FieldFilter{Path: "metadata.ownerReferences[0].name", Operator: FieldOpIn, Values: []string{"y"}},
+			want:   []string{},
+		},
+		{
+			name:   "quoted name with dots",
+			filter: FieldFilter{Path: "metadata.labels['app.kubernetes.io/name']", Operator: FieldOpEquals, Values: []string{"racer"}},
+			want:   []string{"c"},
+		},
+		{
+			name:   "double-quoted name within braces",
+			filter: FieldFilter{Path: `{.metadata.labels["app.kubernetes.io/name"]}`, Operator: FieldOpNotEquals, Values: []string{"racer"}},
+			want:   []string{"a", "b"},
+		},
+		{
+			name:    "unclosed quoted name",
+			filter:  FieldFilter{Path: "metadata.labels['app.kubernetes.io/name]", Operator: FieldOpEquals, Values: []string{"racer"}},
+			wantErr: ErrInvalidFilterParams,
+		},
+		{
+			name:    "unsupported expression",
+			filter:  FieldFilter{Path: "{.metadata.ownerReferences[?(@.name=='x')]}", Operator: FieldOpEquals, Values: []string{"x"}},
+			wantErr: ErrInvalidFilterParams,
+		},
+		{
+			name:    "non-numeric value",
+			filter:  FieldFilter{Path: "status.speed", Operator: FieldOpGreaterThan, Values: []string{"fast"}},
+			wantErr: ErrInvalidFilterParams,
+		},
+		{
+			name:    "unknown operator",
+			filter:  FieldFilter{Path: "status.speed", Operator: "~", Values: []string{"50"}},
+			wantErr: ErrInvalidFilterParams,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := MakeListOptions(tt.filter)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("MakeListOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got, err := o.Filters[0].Filter(objs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Filter() returned %d objects, want %d", len(got), len(tt.want))
+			}
+			for i, obj := range got {
+				if obj.GetName() != tt.want[i] {
+					t.Errorf("Filter()[%d] = %q, want %q", i, obj.GetName(), tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package filter
+
+import (
+	"fmt"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// LabelSelectorFilter implements ObjectFilter and ListOption.
+var _ ObjectFilter = LabelSelectorFilter{}
+var _ ListOption = LabelSelectorFilter{}
+
+// LabelSelectorFilter is an ObjectFilter that matches runtime.Object.GetLabels()
+// against a label selector, the same way as "kubectl get -l" does. At least one of
+// the LabelSelector and Selector fields is required, otherwise ErrInvalidFilterParams
+// is returned. If both are set, the object must match both.
+type LabelSelectorFilter struct {
+	// LabelSelector matches the object's labels using the structured selector type.
+	// +optional
+	LabelSelector *metav1.LabelSelector
+	// Selector matches the object's labels using the string selector syntax, e.g.
+	// "app=foo,tier in (web,api),!legacy".
+	// +optional
+	Selector string
+}
+
+// Filter implements ObjectFilter
+func (f LabelSelectorFilter) Filter(obj runtime.Object) (bool, error) {
+	selector, err := f.selector()
+	if err != nil {
+		return false, err
+	}
+
+	return selector.Matches(labels.Set(obj.GetLabels())), nil
+}
+
+// ApplyToListOptions implements ListOption, and adds itself converted to
+// a ListFilter to ListOptions.Filters.
+func (f LabelSelectorFilter) ApplyToListOptions(target *ListOptions) error {
+	// Validate the selectors already here, to fail fast
+	selector, err := f.selector()
+	if err != nil {
+		return err
+	}
+
+	target.Filters = append(target.Filters, ObjectToListFilter(selectorFilter{selector}))
+	return nil
+}
+
+// selector combines LabelSelector and Selector into one labels.Selector.
+func (f LabelSelectorFilter) selector() (labels.Selector, error) {
+	// Require at least one selector to be set.
+	if f.LabelSelector == nil && len(f.Selector) == 0 {
+		return nil, fmt.Errorf("either the LabelSelectorFilter.LabelSelector or .Selector field must be set: %w", ErrInvalidFilterParams)
+	}
+
+	selector := labels.Everything()
+	if f.LabelSelector != nil {
+		s, err := metav1.LabelSelectorAsSelector(f.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LabelSelectorFilter.LabelSelector: %v: %w", err, ErrInvalidFilterParams)
+		}
+		selector = s
+	}
+
+	if len(f.Selector) != 0 {
+		s, err := labels.Parse(f.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LabelSelectorFilter.Selector: %v: %w", err, ErrInvalidFilterParams)
+		}
+		// Add the parsed requirements to the ones of the structured selector
+		reqs, _ := s.Requirements()
+		selector = selector.Add(reqs...)
+	}
+
+	return selector, nil
+}
+
+// selectorFilter is an ObjectFilter for an already parsed labels.Selector.
+type selectorFilter struct {
+	selector labels.Selector
+}
+
+// Filter implements ObjectFilter
+func (f selectorFilter) Filter(obj runtime.Object) (bool, error) {
+	return f.selector.Matches(labels.Set(obj.GetLabels())), nil
+}
@@ -0,0 +1,86 @@
+package filter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newLabeledObject(name string, labels map[string]string) runtime.Object {
+	return &runtime.PartialObjectImpl{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+	}
+}
+
+func TestLabelSelectorFilter(t *testing.T) {
+	objs := []runtime.Object{
+		newLabeledObject("web", map[string]string{"app": "foo", "tier": "web"}),
+		newLabeledObject("api", map[string]string{"app": "foo", "tier": "api"}),
+		newLabeledObject("legacy", map[string]string{"app": "foo", "tier": "web", "legacy": "true"}),
+		newLabeledObject("db", map[string]string{"app": "bar", "tier": "db"}),
+	}
+
+	tests := []struct {
+		name    string
+		filter  LabelSelectorFilter
+		want    []string
+		wantErr error
+	}{
+		{
+			name:   "string selector",
+			filter: LabelSelectorFilter{Selector: "app=foo,tier in (web,api),!legacy"},
+			want:   []string{"web", "api"},
+		},
+		{
+			name: "structured selector",
+			filter: LabelSelectorFilter{LabelSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"tier": "web"},
+			}},
+			want: []string{"web", "legacy"},
+		},
+		{
+			name: "both selectors",
+			filter: LabelSelectorFilter{
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "web"}},
+				Selector:      "!legacy",
+			},
+			want: []string{"web"},
+		},
+		{
+			name:    "no selector",
+			filter:  LabelSelectorFilter{},
+			wantErr: ErrInvalidFilterParams,
+		},
+		{
+			name:    "invalid selector",
+			filter:  LabelSelectorFilter{Selector: "app in foo"},
+			wantErr: ErrInvalidFilterParams,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := MakeListOptions(tt.filter)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("MakeListOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got, err := o.Filters[0].Filter(objs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Filter() returned %d objects, want %d", len(got), len(tt.want))
+			}
+			for i, obj := range got {
+				if obj.GetName() != tt.want[i] {
+					t.Errorf("Filter()[%d] = %q, want %q", i, obj.GetName(), tt.want[i])
+				}
+			}
+		})
+	}
+}
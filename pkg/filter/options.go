@@ -1,10 +1,19 @@
 package filter
 
+import "fmt"
+
 // ListOptions is a generic struct for listing options.
 type ListOptions struct {
 	// Filters contains a chain of ListFilters, which will be processed in order and pipe the
 	// available objects through before returning.
 	Filters []ListFilter
+
+	// Limit is the maximum number of objects to return. Objects are returned in a
+	// deterministic order, and the limit applies after filtering. Zero means no limit.
+	Limit int64
+	// Continue is a token returned from a previous call, which makes the listing continue
+	// right after the object the token was created for. Empty means start from the beginning.
+	Continue string
 }
 
 // ListOption is an interface which can be passed into e.g. List() methods as a variadic-length
@@ -25,3 +34,30 @@ func MakeListOptions(opts ...ListOption) (*ListOptions, error) {
 	}
 	return o, nil
 }
+
+// Limit implements ListOption.
+var _ ListOption = Limit(0)
+
+// Limit is a ListOption that sets ListOptions.Limit, i.e. the maximum number of objects to return.
+type Limit int64
+
+// ApplyToListOptions implements ListOption.
+func (l Limit) ApplyToListOptions(target *ListOptions) error {
+	if l < 0 {
+		return fmt.Errorf("the Limit must not be negative: %w", ErrInvalidFilterParams)
+	}
+	target.Limit = int64(l)
+	return nil
+}
+
+// Continue implements ListOption.
+var _ ListOption = Continue("")
+
+// Continue is a ListOption that sets ListOptions.Continue, i.e. the token to continue listing from.
+type Continue string
+
+// ApplyToListOptions implements ListOption.
+func (c Continue) ApplyToListOptions(target *ListOptions) error {
+	target.Continue = string(c)
+	return nil
+}
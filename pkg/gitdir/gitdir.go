@@ -90,11 +90,13 @@ type GitDirectory interface {
 	// ErrNotStarted is returned if the repo hasn't been cloned yet.
 	CheckoutMainBranch() error
 
-	// Commit creates a commit of all changes in the current worktree with the given parameters.
+	// Commit creates a commit of all changes to the tracked files in the current worktree with the given
+	// parameters. Untracked files are only added if listed in newFiles, as slash-separated
+	// paths relative to Dir().
 	// It also automatically pushes the branch after the commit.
 	// ErrNotStarted is returned if the repo hasn't been cloned yet.
 	// ErrCannotWriteToReadOnly is returned if opts.AuthMethod wasn't provided.
-	Commit(ctx context.Context, authorName, authorEmail, msg string) error
+	Commit(ctx context.Context, authorName, authorEmail, msg string, newFiles ...string) error
 	// CommitChannel is a channel to where new observed Git SHAs are written.
 	CommitChannel() chan string
 
@@ -383,11 +385,13 @@ func (d *gitDirectory) observeCommit(commit plumbing.Hash) {
 	log.Infof("New commit observed on branch %q: %s", d.Branch, commit)
 }
 
-// Commit creates a commit of all changes in the current worktree with the given parameters.
+// Commit creates a commit of all changes to the tracked files in the current worktree with the given
+// parameters. Untracked files are only added if listed in newFiles, as slash-separated
+// paths relative to Dir().
 // It also automatically pushes the branch after the commit.
 // ErrNotStarted is returned if the repo hasn't been cloned yet.
 // ErrCannotWriteToReadOnly is returned if opts.AuthMethod wasn't provided.
-func (d *gitDirectory) Commit(ctx context.Context, authorName, authorEmail, msg string) error {
+func (d *gitDirectory) Commit(ctx context.Context, authorName, authorEmail, msg string, newFiles ...string) error {
 	// Make sure it's okay to write
 	if err := d.verifyWrite(); err != nil {
 		return err
@@ -402,6 +406,17 @@ func (d *gitDirectory) Commit(ctx context.Context, authorName, authorEmail, msg
 		return nil
 	}
 
+	// Stage the given new files, as CommitOptions.All only stages the changes of already tracked files.
+	// Other untracked files, e.g. ones left behind by interrupted writes, are not committed.
+	for _, file := range newFiles {
+		if status, ok := s[file]; !ok || status.Worktree != git.Untracked {
+			continue
+		}
+		if _, err := d.wt.Add(file); err != nil {
+			return fmt.Errorf("git add %q failed: %v", file, err)
+		}
+	}
+
 	// Do a commit and push
 	log.Debug("commitLoop: Committing all local changes")
 	hash, err := d.wt.Commit(msg, &git.CommitOptions{
@@ -2,8 +2,12 @@ package runtime
 
 import (
 	"fmt"
+	"strings"
 
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 // DefaultNamespace describes the default namespace name used for the system.
@@ -33,6 +37,74 @@ func (id Metav1NameIdentifierFactory) Identify(o interface{}) (Identifyable, boo
 	return nil, false
 }
 
+// ScopedNameIdentifierFactory identifies namespaced objects using "{namespace}/{name}", like
+// Metav1NameIdentifierFactory, and cluster-scoped objects using only "{name}". The scope of a kind
+// is looked up in the RESTMapper; kinds unknown to it are treated as namespaced. The Typer is used
+// to find the GroupVersionKind of objects with empty TypeMeta.
+type ScopedNameIdentifierFactory struct {
+	Mapper meta.RESTMapper
+	Typer  runtime.ObjectTyper
+}
+
+func (id ScopedNameIdentifierFactory) Identify(o interface{}) (Identifyable, bool) {
+	obj, ok := o.(Object)
+	if !ok || len(obj.GetName()) == 0 {
+		return nil, false
+	}
+
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if gvk.Empty() && id.Typer != nil {
+		if gvks, _, err := id.Typer.ObjectKinds(obj); err == nil && len(gvks) > 0 {
+			gvk = gvks[0]
+		}
+	}
+
+	// Only the name identifies cluster-scoped objects, the namespace is ignored
+	if mapping, err := id.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version); err == nil && mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		return NewIdentifier(obj.GetName()), true
+	}
+
+	return Metav1NameIdentifier.Identify(obj)
+}
+
+// NewScopedNameIdentifier returns a ScopedNameIdentifierFactory for the given RESTMapper and ObjectTyper.
+// Use NewRESTMapper to create a RESTMapper for the kinds in a scheme.
+func NewScopedNameIdentifier(mapper meta.RESTMapper, typer runtime.ObjectTyper) IdentifierFactory {
+	return ScopedNameIdentifierFactory{Mapper: mapper, Typer: typer}
+}
+
+// NewRESTMapper returns a RESTMapper for all kinds registered in the given scheme. The kinds in
+// clusterScoped are cluster-scoped, all other kinds are namespaced.
+func NewRESTMapper(scheme *runtime.Scheme, clusterScoped ...schema.GroupKind) meta.RESTMapper {
+	isClusterScoped := make(map[schema.GroupKind]bool, len(clusterScoped))
+	for _, gk := range clusterScoped {
+		isClusterScoped[gk] = true
+	}
+
+	mapper := meta.NewDefaultRESTMapper(scheme.PrioritizedVersionsAllGroups())
+	for gvk := range scheme.AllKnownTypes() {
+		if gvk.Version == runtime.APIVersionInternal {
+			continue
+		}
+
+		scope := meta.RESTScopeNamespace
+		if isClusterScoped[gvk.GroupKind()] {
+			scope = meta.RESTScopeRoot
+		}
+		mapper.Add(gvk, scope)
+	}
+	return mapper
+}
+
+// SplitIdentifier splits an identifier created by Metav1NameIdentifier or a ScopedNameIdentifierFactory
+// into the namespace and name of the object. The namespace is empty for cluster-scoped objects.
+func SplitIdentifier(id string) (namespace, name string) {
+	if i := strings.Index(id, "/"); i != -1 {
+		return id[:i], id[i+1:]
+	}
+	return "", id
+}
+
 type ObjectUIDIdentifierFactory struct{}
 
 func (id ObjectUIDIdentifierFactory) Identify(o interface{}) (Identifyable, bool) {
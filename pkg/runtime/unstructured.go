@@ -0,0 +1,44 @@
+package runtime
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Unstructured is an Object for API types which aren't registered in the scheme, e.g. Kubernetes
+// manifests stored next to the typed Objects. It wraps unstructured.Unstructured, which doesn't
+// implement metav1.ObjectMetaAccessor by itself.
+// +k8s:deepcopy-gen=false
+type Unstructured struct {
+	unstructured.Unstructured
+}
+
+// NewUnstructured wraps the given unstructured.Unstructured, sharing its content.
+func NewUnstructured(u *unstructured.Unstructured) *Unstructured {
+	return &Unstructured{Unstructured: *u}
+}
+
+// GetObjectMeta implements metav1.ObjectMetaAccessor. The metadata is stored in the unstructured
+// content, and accessed through the metav1.Object methods of the Unstructured itself.
+func (u *Unstructured) GetObjectMeta() metav1.Object {
+	return u
+}
+
+// DeepCopyObject implements runtime.Object, returning an *Unstructured.
+func (u *Unstructured) DeepCopyObject() runtime.Object {
+	if u == nil {
+		return nil
+	}
+	return NewUnstructured(u.Unstructured.DeepCopy())
+}
+
+// NewEmptyInstance implements runtime.Unstructured, returning an empty *Unstructured of the same kind.
+func (u *Unstructured) NewEmptyInstance() runtime.Unstructured {
+	out := &Unstructured{}
+	out.GetObjectKind().SetGroupVersionKind(u.GroupVersionKind())
+	return out
+}
+
+var _ Object = &Unstructured{}
+var _ runtime.Unstructured = &Unstructured{}
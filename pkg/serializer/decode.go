@@ -7,6 +7,7 @@ import (
 
 	"github.com/save-abandoned-projects/libgitops/pkg/util"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
@@ -47,6 +48,15 @@ type DecodingOptions struct {
 	// *runtime.Unknown object when running Decode(All) (true value) or to return an error when
 	// any unrecognized type is found (false value). (Default: false)
 	DecodeUnknown *bool
+
+	// DecodeUnstructured specifies whether to decode objects with an unknown GroupVersionKind into an
+	// *unstructured.Unstructured object when running Decode(All). This takes precedence over DecodeUnknown.
+	// The SchemaValidator and PreserveComments options apply to these objects, too. (Default: false)
+	DecodeUnstructured *bool
+
+	// SchemaValidator validates the decoded documents against the OpenAPI schemas of their types,
+	// see NewSchemaValidator. If a document is invalid, a *ValidationError is returned. (Default: nil)
+	SchemaValidator SchemaValidator
 }
 
 type DecodingOptionsFunc func(*DecodingOptions)
@@ -87,6 +97,18 @@ func WithUnknownDecode(unknown bool) DecodingOptionsFunc {
 	}
 }
 
+func WithUnstructuredDecode(unstructured bool) DecodingOptionsFunc {
+	return func(opts *DecodingOptions) {
+		opts.DecodeUnstructured = &unstructured
+	}
+}
+
+func WithSchemaValidationDecode(validator SchemaValidator) DecodingOptionsFunc {
+	return func(opts *DecodingOptions) {
+		opts.SchemaValidator = validator
+	}
+}
+
 func WithDecodingOptions(newOpts DecodingOptions) DecodingOptionsFunc {
 	return func(opts *DecodingOptions) {
 		// TODO: Null-check all of these before using them
@@ -102,6 +124,7 @@ func defaultDecodeOpts() *DecodingOptions {
 		DecodeListElements: util.BoolPtr(true),
 		PreserveComments:   util.BoolPtr(false),
 		DecodeUnknown:      util.BoolPtr(false),
+		DecodeUnstructured: util.BoolPtr(false),
 	}
 }
 
@@ -145,6 +168,10 @@ type decoder struct {
 //
 //	*runtime.Unknown object instead of returning a UnrecognizedTypeError.
 //
+// If opts.DecodeUnstructured is true, any type with an unrecognized apiVersion/kind will be returned as an
+//
+//	*unstructured.Unstructured object instead, taking precedence over opts.DecodeUnknown.
+//
 // opts.DecodeListElements is not applicable in this call.
 func (d *decoder) Decode(fr FrameReader) (runtime.Object, error) {
 	// Read a frame from the FrameReader
@@ -172,7 +199,10 @@ func (d *decoder) decode(doc []byte, into runtime.Object, ct ContentType) (runti
 	if err != nil {
 		// If we asked to decode unknown objects, we are in the Decode(All) (not Into)
 		// codepath, and the error returned was due to that the kind was not registered
-		// in the scheme, decode the document as a *runtime.Unknown
+		// in the scheme, decode the document as an *unstructured.Unstructured or a *runtime.Unknown
+		if *d.opts.DecodeUnstructured && !intoGiven && runtime.IsNotRegisteredError(err) {
+			return d.decodeUnstructured(doc, ct)
+		}
 		if *d.opts.DecodeUnknown && !intoGiven && runtime.IsNotRegisteredError(err) {
 			return d.decodeUnknown(doc, ct)
 		}
@@ -193,6 +223,13 @@ func (d *decoder) decode(doc []byte, into runtime.Object, ct ContentType) (runti
 		return nil, fmt.Errorf("unable to decode %s into %v", gvk, reflect.TypeOf(into))
 	}
 
+	// Validate the document as given, before defaulting and conversion
+	if d.opts.SchemaValidator != nil {
+		if errs := d.opts.SchemaValidator.Validate(doc); len(errs) != 0 {
+			return nil, &ValidationError{GVK: *gvk, Errors: errs}
+		}
+	}
+
 	// Try to preserve comments
 	d.tryToPreserveComments(doc, obj, ct)
 
@@ -222,7 +259,7 @@ func (d *decoder) decode(doc []byte, into runtime.Object, ct ContentType) (runti
 //
 // opts.DecodeListElements is not applicable in this call.
 // opts.ConvertToHub is not applicable in this call.
-// opts.DecodeUnknown is not applicable in this call. In case you want to decode an object into a
+// opts.DecodeUnknown and opts.DecodeUnstructured are not applicable in this call. In case you want to decode an object into a
 //
 //	*runtime.Unknown, just create a runtime.Unknown object and pass the pointer as obj into DecodeInto
 //	and it'll work.
@@ -263,6 +300,10 @@ func (d *decoder) DecodeInto(fr FrameReader, into runtime.Object) error {
 // If opts.DecodeUnknown is true, any type with an unrecognized apiVersion/kind will be returned as a
 //
 //	*runtime.Unknown object instead of returning a UnrecognizedTypeError.
+//
+// If opts.DecodeUnstructured is true, any type with an unrecognized apiVersion/kind will be returned as an
+//
+//	*unstructured.Unstructured object instead, taking precedence over opts.DecodeUnknown.
 func (d *decoder) DecodeAll(fr FrameReader) ([]runtime.Object, error) {
 	objs := []runtime.Object{}
 	for {
@@ -294,6 +335,13 @@ func (d *decoder) decodeUnknown(doc []byte, ct ContentType) (runtime.Object, err
 	return d.decode(doc, &runtime.Unknown{}, ct)
 }
 
+// decodeUnstructured decodes bytes of a certain content type into a returned *unstructured.Unstructured object
+func (d *decoder) decodeUnstructured(doc []byte, ct ContentType) (runtime.Object, error) {
+	// The JSON serializer decodes into unstructured objects directly, without using the scheme.
+	// Unlike for runtime.Unknown, the content type matters, as the comments can be preserved.
+	return d.decode(doc, &unstructured.Unstructured{}, ct)
+}
+
 func (d *decoder) handleDecodeError(doc []byte, origErr error) error {
 	// Parse the document's TypeMeta information
 	gvk, err := extractYAMLTypeMeta(doc)
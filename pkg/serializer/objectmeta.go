@@ -8,6 +8,12 @@ import (
 // toMetaObject converts a runtime.Object to a metav1.Object (containing methods that allow modification of
 // e.g. annotations, labels, name, namespaces, etc.), and reports whether this cast was successful
 func toMetaObject(obj runtime.Object) (metav1.Object, bool) {
+	// Unstructured objects store their metadata in a map, which is accessed through their metav1.Object methods
+	if _, ok := obj.(runtime.Unstructured); ok {
+		metaObj, ok := obj.(metav1.Object)
+		return metaObj, ok
+	}
+
 	// Check if the object has ObjectMeta embedded. If it does, it can be casted to
 	// an ObjectMetaAccessor, which allows us to get operate directly on the ObjectMeta field
 	acc, ok := obj.(metav1.ObjectMetaAccessor)
@@ -68,7 +74,11 @@ func deleteAnnotation(metaObj metav1.Object, key string) {
 		return
 	}
 
-	// Delete the internal annotation and write back to the object.
-	// The map is passed by reference so this automatically updates the underlying annotations object.
+	// Delete the internal annotation and write back to the object. Unstructured objects return a copy
+	// of their annotations, so the map has to be set again. Don't leave an empty map behind.
 	delete(a, key)
+	if len(a) == 0 {
+		a = nil
+	}
+	metaObj.SetAnnotations(a)
 }
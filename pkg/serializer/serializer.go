@@ -100,6 +100,8 @@ type Decoder interface {
 	// 	Otherwise, the decoded object will be left in the external representation.
 	// If opts.DecodeUnknown is true, any type with an unrecognized apiVersion/kind will be returned as a
 	// 	*runtime.Unknown object instead of returning a UnrecognizedTypeError.
+	// If opts.SchemaValidator is set, documents not matching the OpenAPI schema of their type are
+	// 	rejected with a *ValidationError, listing the invalid fields.
 	// opts.DecodeListElements is not applicable in this call.
 	Decode(fr FrameReader) (runtime.Object, error)
 
@@ -137,6 +139,8 @@ type Decoder interface {
 	// 	added into the returning slice. The v1.List will in this case not be returned.
 	// If opts.DecodeUnknown is true, any type with an unrecognized apiVersion/kind will be returned as a
 	// 	*runtime.Unknown object instead of returning a UnrecognizedTypeError.
+	// If opts.SchemaValidator is set, documents not matching the OpenAPI schema of their type are
+	// 	rejected with a *ValidationError, listing the invalid fields.
 	DecodeAll(fr FrameReader) ([]runtime.Object, error)
 }
 
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/conversion"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -539,6 +540,45 @@ func TestDecodeUnknown(t *testing.T) {
 	}
 }
 
+func TestDecodeUnstructured(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         []byte
+		unstructured bool
+		unknown      bool
+		expected     runtime.Object
+		expectedErr  bool
+	}{
+		{"Decode unrecognized kinds into unstructured.Unstructured", unrecognizedGVK, true, false, &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "unknown/v1",
+			"kind":       "YouDontRecognizeMe",
+			"testFooBar": true,
+		}}, false},
+		{"Unstructured takes precedence over unknown", unrecognizedGVK, true, true, &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "unknown/v1",
+			"kind":       "YouDontRecognizeMe",
+			"testFooBar": true,
+		}}, false},
+		{"Decode known kinds into known structs", oneComplex, true, false, &runtimetest.ExternalComplex{TypeMeta: complexv1Meta, String: "bar"}, false},
+		{"No support for unrecognized", unrecognizedGVK, false, false, nil, true},
+	}
+
+	for _, rt := range tests {
+		t.Run(rt.name, func(t2 *testing.T) {
+			obj, actual := ourserializer.Decoder(
+				WithUnstructuredDecode(rt.unstructured),
+				WithUnknownDecode(rt.unknown),
+			).Decode(NewYAMLFrameReader(FromBytes(rt.data)))
+			if (actual != nil) != rt.expectedErr {
+				t2.Errorf("expected error %t but actual %t: %v", rt.expectedErr, actual != nil, actual)
+			}
+			if rt.expected != nil && !reflect.DeepEqual(obj, rt.expected) {
+				t2.Errorf("expected %#v but actual %#v", rt.expected, obj)
+			}
+		})
+	}
+}
+
 func TestRoundtrip(t *testing.T) {
 	tests := []struct {
 		name string
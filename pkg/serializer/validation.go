@@ -0,0 +1,203 @@
+package serializer
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/kube-openapi/pkg/common"
+	openapierrors "k8s.io/kube-openapi/pkg/validation/errors"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"k8s.io/kube-openapi/pkg/validation/strfmt"
+	"k8s.io/kube-openapi/pkg/validation/validate"
+	"sigs.k8s.io/yaml"
+)
+
+// SchemaValidator validates YAML or JSON documents against the OpenAPI schemas of their types.
+type SchemaValidator interface {
+	// Validate validates the given YAML or JSON document against the schema of the type registered
+	// for its apiVersion and kind. The returned errors are qualified with the path of the invalid
+	// field, e.g. "spec.brand". Documents of kinds without a schema are not validated.
+	Validate(doc []byte) field.ErrorList
+}
+
+// NewSchemaValidator creates a SchemaValidator for the types registered in the given scheme, using
+// the OpenAPI definitions returned by getDefinitions, e.g. the GetOpenAPIDefinitions func generated
+// by openapi-gen. The definitions are keyed by the Go package path and name of their types. References
+// to types without a definition (e.g. metav1.ObjectMeta, if not generated) accept any value.
+func NewSchemaValidator(scheme *runtime.Scheme, getDefinitions common.GetOpenAPIDefinitions) SchemaValidator {
+	defs := getDefinitions(func(path string) spec.Ref {
+		return spec.MustCreateRef(path)
+	})
+
+	v := &schemaValidator{scheme: scheme, validators: make(map[string]*validate.SchemaValidator, len(defs))}
+	for name, def := range defs {
+		s := expandSchema(def.Schema, defs, map[string]bool{name: true})
+		v.validators[name] = validate.NewSchemaValidator(&s, nil, "", strfmt.Default)
+	}
+	return v
+}
+
+// schemaValidator implements SchemaValidator.
+type schemaValidator struct {
+	scheme *runtime.Scheme
+	// validators maps the definition names to the validators of the expanded schemas
+	validators map[string]*validate.SchemaValidator
+}
+
+func (v *schemaValidator) Validate(doc []byte) field.ErrorList {
+	var value interface{}
+	// The yaml package supports both YAML and JSON
+	if err := yaml.Unmarshal(doc, &value); err != nil {
+		return field.ErrorList{field.Invalid(field.NewPath(""), "", err.Error())}
+	}
+
+	gvk, err := extractYAMLTypeMeta(doc)
+	if err != nil {
+		return field.ErrorList{field.Invalid(field.NewPath("kind"), "", err.Error())}
+	}
+
+	validator, ok := v.validators[v.definitionName(*gvk)]
+	if !ok {
+		return nil
+	}
+
+	return toFieldErrors(validator.Validate(value))
+}
+
+// definitionName returns the name of the OpenAPI definition for the type registered for gvk, in the
+// form <package path>.<type name>. If the kind is not registered, an empty string is returned.
+func (v *schemaValidator) definitionName(gvk schema.GroupVersionKind) string {
+	obj, err := v.scheme.New(gvk)
+	if err != nil {
+		return ""
+	}
+
+	t := reflect.TypeOf(obj)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
+// expandSchema inlines the references of s, as the validator doesn't support them. References to
+// definitions which don't exist, or are already being expanded (i.e. recursive types), accept any value.
+func expandSchema(s spec.Schema, defs map[string]common.OpenAPIDefinition, expanding map[string]bool) spec.Schema {
+	if ref := s.Ref.String(); len(ref) != 0 {
+		def, ok := defs[ref]
+		if !ok || expanding[ref] {
+			return spec.Schema{}
+		}
+
+		expanding[ref] = true
+		defer delete(expanding, ref)
+		return expandSchema(def.Schema, defs, expanding)
+	}
+
+	expand := func(s spec.Schema) spec.Schema { return expandSchema(s, defs, expanding) }
+
+	if len(s.Properties) != 0 {
+		props := make(map[string]spec.Schema, len(s.Properties))
+		for name, prop := range s.Properties {
+			props[name] = expand(prop)
+		}
+		s.Properties = props
+	}
+	if s.Items != nil {
+		items := *s.Items
+		if items.Schema != nil {
+			schema := expand(*items.Schema)
+			items.Schema = &schema
+		}
+		if len(items.Schemas) != 0 {
+			items.Schemas = expandSchemas(items.Schemas, expand)
+		}
+		s.Items = &items
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+		schema := expand(*s.AdditionalProperties.Schema)
+		s.AdditionalProperties = &spec.SchemaOrBool{Allows: true, Schema: &schema}
+	}
+	if s.Not != nil {
+		schema := expand(*s.Not)
+		s.Not = &schema
+	}
+	s.AllOf = expandSchemas(s.AllOf, expand)
+	s.AnyOf = expandSchemas(s.AnyOf, expand)
+	s.OneOf = expandSchemas(s.OneOf, expand)
+	return s
+}
+
+func expandSchemas(schemas []spec.Schema, expand func(spec.Schema) spec.Schema) []spec.Schema {
+	if len(schemas) == 0 {
+		return schemas
+	}
+
+	result := make([]spec.Schema, 0, len(schemas))
+	for _, s := range schemas {
+		result = append(result, expand(s))
+	}
+	return result
+}
+
+// toFieldErrors converts the errors of the validation result to path-qualified field errors
+func toFieldErrors(result *validate.Result) field.ErrorList {
+	if result.IsValid() {
+		return nil
+	}
+
+	var errs field.ErrorList
+	for _, err := range result.Errors {
+		verr, ok := err.(*openapierrors.Validation)
+		if !ok {
+			errs = append(errs, field.Invalid(field.NewPath(""), "", err.Error()))
+			continue
+		}
+
+		path := field.NewPath("")
+		if len(verr.Name) != 0 && verr.Name != "." {
+			path = field.NewPath(strings.TrimPrefix(verr.Name, "."))
+		}
+		value := verr.Value
+		if value == nil {
+			value = ""
+		}
+
+		switch verr.Code() {
+		case openapierrors.RequiredFailCode:
+			errs = append(errs, field.Required(path, ""))
+		case openapierrors.EnumFailCode:
+			values := make([]string, 0, len(verr.Values))
+			for _, v := range verr.Values {
+				if s, ok := v.(string); ok {
+					values = append(values, s)
+				} else {
+					b, _ := json.Marshal(v)
+					values = append(values, string(b))
+				}
+			}
+			errs = append(errs, field.NotSupported(path, value, values))
+		case openapierrors.InvalidTypeCode:
+			errs = append(errs, field.TypeInvalid(path, value, verr.Error()))
+		default:
+			// e.g. openapierrors.PatternFailCode
+			errs = append(errs, field.Invalid(path, value, verr.Error()))
+		}
+	}
+	return errs
+}
+
+// ValidationError is returned when a decoded document doesn't validate against its schema.
+type ValidationError struct {
+	GVK    schema.GroupVersionKind
+	Errors field.ErrorList
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s failed schema validation: %v", e.GVK, e.Errors.ToAggregate())
+}
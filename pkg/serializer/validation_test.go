@@ -0,0 +1,98 @@
+package serializer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+func testOpenAPIDefinitions(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+	return map[string]common.OpenAPIDefinition{
+		"k8s.io/apimachinery/pkg/runtime/testing.ExternalComplex": {
+			Schema: spec.Schema{
+				SchemaProps: spec.SchemaProps{
+					Type: []string{"object"},
+					Properties: map[string]spec.Schema{
+						"string": {SchemaProps: spec.SchemaProps{Type: []string{"string"}, Pattern: "^[a-z]+$"}},
+						"int":    {SchemaProps: spec.SchemaProps{Type: []string{"integer"}, Enum: []interface{}{1, 2}}},
+						"bool":   {SchemaProps: spec.SchemaProps{Ref: ref("example.com/types.Bool")}},
+						"Int64":  {SchemaProps: spec.SchemaProps{Ref: ref("example.com/types.Unknown")}},
+					},
+					Required: []string{"string"},
+				},
+			},
+		},
+		"example.com/types.Bool": {
+			Schema: spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"boolean"}}},
+		},
+	}
+}
+
+func TestSchemaValidator(t *testing.T) {
+	v := NewSchemaValidator(scheme, testOpenAPIDefinitions)
+
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr []string
+	}{
+		{
+			name: "valid",
+			doc:  `{"apiVersion":"foogroup/v1alpha1","kind":"Complex","string":"foo","int":1,"bool":true,"Int64":"anything"}`,
+		},
+		{
+			name:    "required",
+			doc:     `{"apiVersion":"foogroup/v1alpha1","kind":"Complex"}`,
+			wantErr: []string{"string: Required value"},
+		},
+		{
+			name:    "type through a reference",
+			doc:     `{"apiVersion":"foogroup/v1alpha1","kind":"Complex","string":"foo","bool":"yes"}`,
+			wantErr: []string{"bool: Invalid value: \"string\": bool in body must be of type boolean"},
+		},
+		{
+			name:    "enum",
+			doc:     "apiVersion: foogroup/v1alpha1\nkind: Complex\nstring: foo\nint: 3\n",
+			wantErr: []string{`int: Unsupported value: 3: supported values: "1", "2"`},
+		},
+		{
+			name:    "pattern",
+			doc:     "apiVersion: foogroup/v1alpha1\nkind: Complex\nstring: Foo\n",
+			wantErr: []string{`string: Invalid value: "Foo"`},
+		},
+		{
+			name: "kind without a schema",
+			doc:  `{"apiVersion":"foogroup/v1alpha1","kind":"Simple","testString":1}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := v.Validate([]byte(tt.doc))
+			if len(errs) != len(tt.wantErr) {
+				t.Fatalf("expected errors %v, got %v", tt.wantErr, errs)
+			}
+			for i, err := range errs {
+				if !strings.HasPrefix(err.Error(), tt.wantErr[i]) {
+					t.Errorf("expected error %q, got %q", tt.wantErr[i], err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestDecoder_SchemaValidation(t *testing.T) {
+	d := ourserializer.Decoder(WithSchemaValidationDecode(NewSchemaValidator(scheme, testOpenAPIDefinitions)))
+
+	_, err := d.Decode(NewYAMLFrameReader(FromBytes([]byte("apiVersion: foogroup/v1alpha1\nkind: Complex\nstring: Foo\n"))))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Field != "string" {
+		t.Fatalf("expected a ValidationError for string, got %v", err)
+	}
+
+	if _, err := d.Decode(NewYAMLFrameReader(FromBytes([]byte("apiVersion: foogroup/v1alpha1\nkind: Complex\nstring: foo\n")))); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,358 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+	patchutil "github.com/save-abandoned-projects/libgitops/pkg/util/patch"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Operation is the kind of write an admission hook is called for.
+type Operation string
+
+const (
+	OperationCreate Operation = "CREATE"
+	OperationUpdate Operation = "UPDATE"
+	OperationPatch  Operation = "PATCH"
+	OperationApply  Operation = "APPLY"
+	OperationDelete Operation = "DELETE"
+)
+
+// AdmissionAttributes describe the write an admission hook is called for.
+type AdmissionAttributes struct {
+	// Operation is the kind of write
+	Operation Operation
+	// Subresource is "status" for UpdateStatus (with OperationUpdate), and empty otherwise
+	Subresource string
+	// Key is the key of the written Object
+	Key ObjectKey
+	// OldObject is the stored Object. It is nil for OperationCreate.
+	OldObject runtime.Object
+	// Object is the Object to be stored. It is nil for OperationDelete. For OperationApply, it is the
+	// applied Object, before being merged into OldObject. Mutating hooks may modify it in place.
+	Object runtime.Object
+	// FieldManager is the field manager given to Apply. It is empty for the other Operations.
+	FieldManager string
+}
+
+// AdmissionHook is the common interface of MutatingHook and ValidatingHook.
+type AdmissionHook interface {
+	// Handles returns true if the hook should be called for the given Operation
+	Handles(op Operation) bool
+}
+
+// MutatingHook is an AdmissionHook which may modify the Object to be stored.
+type MutatingHook interface {
+	AdmissionHook
+	// Admit may modify attrs.Object. Returning errors rejects the write.
+	Admit(attrs AdmissionAttributes) field.ErrorList
+}
+
+// ValidatingHook is an AdmissionHook which validates the Object to be stored,
+// after all MutatingHooks have been called.
+type ValidatingHook interface {
+	AdmissionHook
+	// Validate must not modify attrs.Object. Returning errors rejects the write.
+	Validate(attrs AdmissionAttributes) field.ErrorList
+}
+
+// AdmissionFunc is the signature of the functions given to NewMutatingHook and NewValidatingHook.
+type AdmissionFunc func(attrs AdmissionAttributes) field.ErrorList
+
+// NewMutatingHook creates a MutatingHook calling fn for the given Operations, or for all if none are given.
+func NewMutatingHook(fn AdmissionFunc, ops ...Operation) MutatingHook {
+	return &mutatingFuncHook{funcHook{fn: fn, ops: ops}}
+}
+
+// NewValidatingHook creates a ValidatingHook calling fn for the given Operations, or for all if none are given.
+func NewValidatingHook(fn AdmissionFunc, ops ...Operation) ValidatingHook {
+	return &validatingFuncHook{funcHook{fn: fn, ops: ops}}
+}
+
+// funcHook implements AdmissionHook for an AdmissionFunc.
+type funcHook struct {
+	fn  AdmissionFunc
+	ops []Operation
+}
+
+func (h *funcHook) Handles(op Operation) bool {
+	if len(h.ops) == 0 {
+		return true
+	}
+	for _, o := range h.ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// mutatingFuncHook implements MutatingHook.
+type mutatingFuncHook struct{ funcHook }
+
+func (h *mutatingFuncHook) Admit(attrs AdmissionAttributes) field.ErrorList { return h.fn(attrs) }
+
+// validatingFuncHook implements ValidatingHook.
+type validatingFuncHook struct{ funcHook }
+
+func (h *validatingFuncHook) Validate(attrs AdmissionAttributes) field.ErrorList { return h.fn(attrs) }
+
+// InvalidError is returned when a write is rejected because the Object is invalid. It
+// matches ErrInvalid using errors.Is, use errors.As to access the field errors.
+type InvalidError struct {
+	Key    ObjectKey
+	Errors field.ErrorList
+}
+
+func (e *InvalidError) Error() string {
+	return fmt.Sprintf("%s is invalid: %v", e.Key, e.Errors.ToAggregate())
+}
+
+func (e *InvalidError) Unwrap() error {
+	return ErrInvalid
+}
+
+// WithAdmission wraps the given Storage, calling the given hooks for every Create, Update,
+// UpdateStatus, Patch, Apply and Delete. The MutatingHooks are called first, in the given order, followed by the
+// ValidatingHooks, in the given order. A hook implementing both interfaces is called in both
+// phases. If any hook returns errors, the write is rejected with an InvalidError, and later hooks
+// aren't called. If no hooks are given, the Storage is returned as-is.
+//
+// Patches are applied in memory to build the Object passed to the hooks. As the mutating hooks may
+// change it, the admitted Object is then handed to the wrapped Storage's Patch as a JSON merge patch
+// against the stored Object, so that the format and comments of the stored content are kept. All
+// writes are conditional on the stored Object not changing after it was passed to the hooks; if it
+// does, ErrConflict is returned.
+func WithAdmission(s Storage, hooks ...AdmissionHook) Storage {
+	if len(hooks) == 0 {
+		return s
+	}
+
+	return &admissionStorage{Storage: s, hooks: hooks, patcher: patchutil.NewPatcher(s.Serializer())}
+}
+
+// admissionStorage implements Storage.
+type admissionStorage struct {
+	Storage
+	hooks   []AdmissionHook
+	patcher patchutil.Patcher
+}
+
+func (s *admissionStorage) Create(obj runtime.Object) error {
+	key, err := s.ObjectKeyFor(obj)
+	if err != nil {
+		return err
+	}
+
+	if err := s.admit(AdmissionAttributes{Operation: OperationCreate, Key: key, Object: obj}); err != nil {
+		return err
+	}
+
+	return s.Storage.Create(obj)
+}
+
+func (s *admissionStorage) Update(obj runtime.Object) error {
+	key, err := s.ObjectKeyFor(obj)
+	if err != nil {
+		return err
+	}
+
+	old, err := s.Storage.Get(key)
+	if err != nil {
+		return err
+	}
+
+	// Make sure the object the hooks saw is the one being updated
+	if len(obj.GetResourceVersion()) == 0 {
+		obj.SetResourceVersion(old.GetResourceVersion())
+	}
+
+	if err := s.admit(AdmissionAttributes{Operation: OperationUpdate, Key: key, OldObject: old, Object: obj}); err != nil {
+		return err
+	}
+
+	return s.Storage.Update(obj)
+}
+
+func (s *admissionStorage) UpdateStatus(obj runtime.Object) error {
+	key, err := s.ObjectKeyFor(obj)
+	if err != nil {
+		return err
+	}
+
+	old, err := s.Storage.Get(key)
+	if err != nil {
+		return err
+	}
+
+	// Make sure the object the hooks saw is the one being updated
+	if len(obj.GetResourceVersion()) == 0 {
+		obj.SetResourceVersion(old.GetResourceVersion())
+	}
+
+	attrs := AdmissionAttributes{Operation: OperationUpdate, Subresource: statusField, Key: key, OldObject: old, Object: obj}
+	if err := s.admit(attrs); err != nil {
+		return err
+	}
+
+	return s.Storage.UpdateStatus(obj)
+}
+
+func (s *admissionStorage) Apply(obj runtime.Object, fieldManager string, force bool) error {
+	key, err := s.ObjectKeyFor(obj)
+	if err != nil {
+		return err
+	}
+
+	// Apply creates the Object if it doesn't exist
+	old, err := s.Storage.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		old = nil
+	} else if err != nil {
+		return err
+	}
+
+	// Make sure the object the hooks saw is the one being applied to
+	if old != nil && len(obj.GetResourceVersion()) == 0 {
+		obj.SetResourceVersion(old.GetResourceVersion())
+	}
+
+	attrs := AdmissionAttributes{Operation: OperationApply, Key: key, OldObject: old, Object: obj, FieldManager: fieldManager}
+	if err := s.admit(attrs); err != nil {
+		return err
+	}
+
+	return s.Storage.Apply(obj, fieldManager, force)
+}
+
+func (s *admissionStorage) Patch(key ObjectKey, patchType types.PatchType, patch []byte) error {
+	rv, patch, err := resourceVersionFromPatch(patchType, patch)
+	if err != nil {
+		return err
+	}
+
+	old, err := s.Storage.Get(key)
+	if err != nil {
+		return err
+	}
+	if err := (Preconditions{ResourceVersion: rv}).Check(key, old.GetResourceVersion()); err != nil {
+		return err
+	}
+
+	obj, err := s.applyPatch(key, old, patchType, patch)
+	if err != nil {
+		return err
+	}
+	obj.SetResourceVersion(old.GetResourceVersion())
+
+	if err := s.admit(AdmissionAttributes{Operation: OperationPatch, Key: key, OldObject: old, Object: obj}); err != nil {
+		return err
+	}
+
+	// Hand the admitted Object over as a patch, so the format and comments of the stored content are kept
+	admitted, err := s.createMergePatch(old, obj)
+	if err != nil {
+		return err
+	}
+	return s.Storage.Patch(key, types.MergePatchType, admitted)
+}
+
+func (s *admissionStorage) Delete(key ObjectKey, opts ...DeleteOption) error {
+	old, err := s.Storage.Get(key)
+	if err != nil {
+		return err
+	}
+
+	// Make sure the object the hooks saw is the one being deleted
+	o := MakeDeleteOptions(opts...)
+	if err := o.Preconditions.Check(key, old.GetResourceVersion()); err != nil {
+		return err
+	}
+
+	if err := s.admit(AdmissionAttributes{Operation: OperationDelete, Key: key, OldObject: old}); err != nil {
+		return err
+	}
+
+	return s.Storage.Delete(key, Preconditions{ResourceVersion: old.GetResourceVersion()})
+}
+
+// createMergePatch creates a JSON merge patch from old to obj, which sets the resourceVersion of obj as a precondition.
+func (s *admissionStorage) createMergePatch(old, obj runtime.Object) ([]byte, error) {
+	old = old.DeepCopyObject().(runtime.Object)
+	old.SetResourceVersion("")
+
+	var oldContent, newContent bytes.Buffer
+	if err := s.Serializer().Encoder().Encode(serializer.NewJSONFrameWriter(&oldContent), old); err != nil {
+		return nil, err
+	}
+	if err := s.Serializer().Encoder().Encode(serializer.NewJSONFrameWriter(&newContent), obj); err != nil {
+		return nil, err
+	}
+
+	return jsonpatch.CreateMergePatch(oldContent.Bytes(), newContent.Bytes())
+}
+
+// applyPatch returns a new Object with the given patch applied to old.
+func (s *admissionStorage) applyPatch(key ObjectKey, old runtime.Object, patchType types.PatchType, patch []byte) (runtime.Object, error) {
+	var oldContent bytes.Buffer
+	if err := s.Serializer().Encoder().Encode(serializer.NewJSONFrameWriter(&oldContent), old); err != nil {
+		return nil, err
+	}
+
+	newContent, err := s.patcher.Apply(oldContent.Bytes(), patch, patchType, key.GetGVK())
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the result to the same version as old. Objects of kinds unknown to the scheme were
+	// read as unstructured ones by the wrapped Storage (see WithUnstructured), and stay unstructured.
+	gvk := old.GetObjectKind().GroupVersionKind()
+	_, isUnstructured := old.(*runtime.Unstructured)
+	obj, err := s.Serializer().Decoder(
+		serializer.WithConvertToHubDecode(gvk.Version == kruntime.APIVersionInternal),
+		serializer.WithUnstructuredDecode(isUnstructured),
+	).Decode(serializer.NewJSONFrameReader(serializer.FromBytes(newContent)))
+	if err != nil {
+		return nil, err
+	}
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		obj = runtime.NewUnstructured(u)
+	}
+
+	result, ok := obj.(runtime.Object)
+	if !ok {
+		return nil, fmt.Errorf("can't convert to libgitops.runtime.Object")
+	}
+
+	result.GetObjectKind().SetGroupVersionKind(gvk)
+	return result, nil
+}
+
+// admit calls the mutating, and then the validating hooks handling the operation.
+func (s *admissionStorage) admit(attrs AdmissionAttributes) error {
+	for _, hook := range s.hooks {
+		if h, ok := hook.(MutatingHook); ok && h.Handles(attrs.Operation) {
+			if errs := h.Admit(attrs); len(errs) != 0 {
+				return &InvalidError{Key: attrs.Key, Errors: errs}
+			}
+		}
+	}
+
+	for _, hook := range s.hooks {
+		if h, ok := hook.(ValidatingHook); ok && h.Handles(attrs.Operation) {
+			if errs := h.Validate(attrs); len(errs) != 0 {
+				return &InvalidError{Key: attrs.Key, Errors: errs}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestWithAdmission(t *testing.T) {
+	var calls []string
+	// Default the brand, and require it to be non-empty and not Saab
+	defaultBrand := NewMutatingHook(func(attrs AdmissionAttributes) field.ErrorList {
+		calls = append(calls, "mutate "+string(attrs.Operation))
+		if car := attrs.Object.(*v1alpha1.Car); car.Spec.Brand == "" {
+			car.Spec.Brand = "Volvo"
+		}
+		return nil
+	}, OperationCreate, OperationUpdate, OperationPatch)
+	validateBrand := NewValidatingHook(func(attrs AdmissionAttributes) field.ErrorList {
+		calls = append(calls, "validate "+string(attrs.Operation))
+		if attrs.Operation == OperationDelete {
+			if attrs.OldObject.(*v1alpha1.Car).Spec.Brand == "Tesla" {
+				return field.ErrorList{field.Forbidden(field.NewPath("spec", "brand"), "Teslas can't be deleted")}
+			}
+			return nil
+		}
+		if brand := attrs.Object.(*v1alpha1.Car).Spec.Brand; brand == "Saab" {
+			return field.ErrorList{field.NotSupported(field.NewPath("spec", "brand"), brand, []string{"Volvo", "Tesla"})}
+		}
+		return nil
+	})
+
+	s := WithAdmission(newTestStorage(NewMemoryRawStorage(serializer.ContentTypeJSON)), validateBrand, defaultBrand)
+	key := NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/foo"))
+
+	tests := []struct {
+		name      string
+		write     func() error
+		wantCalls []string
+		wantErr   string
+		wantBrand string
+	}{
+		{
+			name:      "create is mutated",
+			write:     func() error { return s.Create(newTestCar("foo", "")) },
+			wantCalls: []string{"mutate CREATE", "validate CREATE"},
+			wantBrand: "Volvo",
+		},
+		{
+			name:      "invalid update",
+			write:     func() error { return s.Update(newTestCar("foo", "Saab")) },
+			wantCalls: []string{"mutate UPDATE", "validate UPDATE"},
+			wantErr:   "spec.brand: Unsupported value",
+			wantBrand: "Volvo",
+		},
+		{
+			name:      "invalid patch",
+			write:     func() error { return s.Patch(key, types.StrategicMergePatchType, []byte(`{"spec":{"brand":"Saab"}}`)) },
+			wantCalls: []string{"mutate PATCH", "validate PATCH"},
+			wantErr:   "spec.brand: Unsupported value",
+			wantBrand: "Volvo",
+		},
+		{
+			name:      "valid patch",
+			write:     func() error { return s.Patch(key, types.StrategicMergePatchType, []byte(`{"spec":{"brand":"Tesla"}}`)) },
+			wantCalls: []string{"mutate PATCH", "validate PATCH"},
+			wantBrand: "Tesla",
+		},
+		{
+			name:      "rejected delete",
+			write:     func() error { return s.Delete(key) },
+			wantCalls: []string{"validate DELETE"},
+			wantErr:   "Teslas can't be deleted",
+			wantBrand: "Tesla",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			err := tt.write()
+			if len(tt.wantErr) == 0 && err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.wantErr) != 0 && (!errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("expected an ErrInvalid containing %q, got %v", tt.wantErr, err)
+			}
+			if strings.Join(calls, ",") != strings.Join(tt.wantCalls, ",") {
+				t.Errorf("expected calls %v, got %v", tt.wantCalls, calls)
+			}
+
+			obj, err := s.Get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if brand := obj.(*v1alpha1.Car).Spec.Brand; brand != tt.wantBrand {
+				t.Errorf("expected brand %q, got %q", tt.wantBrand, brand)
+			}
+		})
+	}
+
+	// The field errors can be accessed using errors.As
+	var invalid *InvalidError
+	if err := s.Create(newTestCar("bar", "Saab")); !errors.As(err, &invalid) || len(invalid.Errors) != 1 || invalid.Errors[0].Field != "spec.brand" {
+		t.Errorf("expected an InvalidError for spec.brand, got %v", err)
+	}
+}
+
+func TestWithAdmissionHooks(t *testing.T) {
+	rejectSaab := NewValidatingHook(func(attrs AdmissionAttributes) field.ErrorList {
+		if brand := attrs.Object.(*v1alpha1.Car).Spec.Brand; brand == "Saab" {
+			return field.ErrorList{field.NotSupported(field.NewPath("spec", "brand"), brand, []string{"Volvo"})}
+		}
+		return nil
+	}, OperationCreate)
+
+	s := NewGenericStorage(NewMemoryRawStorage(serializer.ContentTypeJSON), scheme.Serializer,
+		[]runtime.IdentifierFactory{runtime.Metav1NameIdentifier}, WithAdmissionHooks(rejectSaab))
+	if err := s.Create(newTestCar("foo", "Saab")); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid, got %v", err)
+	}
+	if err := s.Create(newTestCar("foo", "Volvo")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithAdmission_UnstructuredPatch(t *testing.T) {
+	cmGVK := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	key := NewObjectKey(NewKindKey(cmGVK), runtime.NewIdentifier("default/foo"))
+	raw := NewMemoryRawStorage(serializer.ContentTypeYAML)
+	if err := raw.Write(key, []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+  namespace: default
+data:
+  color: blue
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reject the color red, the hooks receive unstructured Objects for kinds unknown to the scheme
+	rejectRed := NewValidatingHook(func(attrs AdmissionAttributes) field.ErrorList {
+		u := attrs.Object.(*runtime.Unstructured)
+		if color, _, _ := unstructured.NestedString(u.Object, "data", "color"); color == "red" {
+			return field.ErrorList{field.Forbidden(field.NewPath("data", "color"), "red isn't allowed")}
+		}
+		return nil
+	}, OperationPatch)
+	s := NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+		WithUnstructured(true), WithAdmissionHooks(rejectRed))
+
+	if err := s.Patch(key, types.MergePatchType, []byte(`{"data":{"color":"red"}}`)); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid, got %v", err)
+	}
+	if err := s.Patch(key, types.MergePatchType, []byte(`{"data":{"color":"green"}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if color, _, _ := unstructured.NestedString(obj.(*runtime.Unstructured).Object, "data", "color"); color != "green" {
+		t.Errorf("expected color green, got %q", color)
+	}
+}
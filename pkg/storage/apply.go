@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+)
+
+// DefaultFieldManager is the field manager recorded in metadata.managedFields for the changes done
+// by Update and Patch, once an Object is managed by Apply. Objects which have never been applied
+// don't get any metadata.managedFields.
+const DefaultFieldManager = "libgitops"
+
+// Apply merges the fields set in the given Object into the stored Object, tracking their ownership
+// in metadata.managedFields. Conflicts with fields owned by other managers return ErrConflict,
+// unless force is set. The given Object is updated to the stored state.
+func (s *GenericStorage) Apply(obj runtime.Object, fieldManager string, force bool) error {
+	if len(fieldManager) == 0 {
+		return fmt.Errorf("a field manager is required for Apply")
+	}
+
+	key, err := s.ObjectKeyFor(obj)
+	if err != nil {
+		return err
+	}
+
+	fm, err := s.fieldManager(key.GetGVK())
+	if err != nil {
+		return err
+	}
+
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
+	exists := s.raw.Exists(key)
+	if rv := obj.GetResourceVersion(); exists || len(rv) != 0 {
+		if err := s.checkResourceVersion(key, rv); err != nil {
+			return err
+		}
+	}
+
+	// Apply to an empty Object if it doesn't exist yet
+	var old runtime.Object
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(key.GetGVK())
+	if exists {
+		if old, err = s.Get(key); err != nil {
+			return err
+		}
+		if live, err = toJSONUnstructured(old); err != nil {
+			return err
+		}
+	}
+
+	applied, err := toJSONUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	// Unstructured Objects only contain the fields that were set
+	if _, ok := obj.(kruntime.Unstructured); !ok {
+		pruneZeroValues(applied.Object)
+	}
+
+	merged, err := fm.Apply(live, applied, fieldManager, force)
+	if apierrors.IsConflict(err) {
+		return fmt.Errorf("%s: %w: %v", key, ErrConflict, err)
+	} else if err != nil {
+		return err
+	}
+
+	if err := fromUnstructured(merged.(kruntime.Unstructured).UnstructuredContent(), obj); err != nil {
+		return err
+	}
+
+	if exists {
+		if err := s.prepareUpdate(obj, old); err != nil {
+			return err
+		}
+	} else if obj.GetGeneration() == 0 {
+		// The generation of new objects starts at 1
+		obj.SetGeneration(1)
+	}
+
+	return s.write(key, obj)
+}
+
+// updateManagedFields records the changes from old to obj in the metadata.managedFields
+// of obj as done by DefaultFieldManager, if old is managed by Apply.
+func (s *GenericStorage) updateManagedFields(key ObjectKey, obj, old runtime.Object) error {
+	if len(old.GetManagedFields()) == 0 {
+		return nil
+	}
+
+	fm, err := s.fieldManager(key.GetGVK())
+	if err != nil {
+		return err
+	}
+
+	live, err := toJSONUnstructured(old)
+	if err != nil {
+		return err
+	}
+	newObj, err := toJSONUnstructured(obj)
+	if err != nil {
+		return err
+	}
+
+	updated, err := fm.Update(live, newObj, DefaultFieldManager)
+	if err != nil {
+		return err
+	}
+
+	return fromUnstructured(updated.(kruntime.Unstructured).UnstructuredContent(), obj)
+}
+
+// fieldManager returns a FieldManager for the given kind. If the StatusSubresource option
+// is set, changes to the status aren't tracked, as they are ignored by Apply.
+func (s *GenericStorage) fieldManager(gvk schema.GroupVersionKind) (*managedfields.FieldManager, error) {
+	var resetFields map[fieldpath.APIVersion]*fieldpath.Set
+	if *s.opts.StatusSubresource {
+		resetFields = map[fieldpath.APIVersion]*fieldpath.Set{
+			fieldpath.APIVersion(gvk.GroupVersion().String()): fieldpath.NewSet(fieldpath.MakePathOrDie(statusField)),
+		}
+	}
+
+	scheme := unstructuredScheme{s.serializer.Scheme()}
+	return managedfields.NewDefaultFieldManager(s.opts.TypeConverter, scheme, scheme, scheme, gvk, gvk.GroupVersion(), "", resetFields)
+}
+
+// unstructuredScheme creates, converts and defaults unstructured Objects using the types registered
+// in the scheme. The FieldManager is only given unstructured Objects, see toJSONUnstructured.
+type unstructuredScheme struct {
+	scheme *kruntime.Scheme
+}
+
+func (s unstructuredScheme) New(gvk schema.GroupVersionKind) (kruntime.Object, error) {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	return u, nil
+}
+
+// Default is a no-op, as GenericStorage doesn't default the Objects it writes
+func (s unstructuredScheme) Default(kruntime.Object) {}
+
+func (s unstructuredScheme) Convert(in, out, context interface{}) error {
+	return s.scheme.Convert(in, out, context)
+}
+
+func (s unstructuredScheme) ConvertToVersion(in kruntime.Object, gv kruntime.GroupVersioner) (kruntime.Object, error) {
+	gvk := in.GetObjectKind().GroupVersionKind()
+	target, ok := gv.KindForGroupVersionKinds([]schema.GroupVersionKind{gvk})
+	if !ok {
+		return nil, fmt.Errorf("%s can't be converted to %v", gvk, gv)
+	}
+	if target == gvk {
+		return in, nil
+	}
+
+	typed, err := s.toTyped(in)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.scheme.ConvertToVersion(typed, gv)
+	if err != nil {
+		return nil, err
+	}
+	u, err := toJSONUnstructured(out)
+	if err != nil {
+		return nil, err
+	}
+	u.SetGroupVersionKind(target)
+	return u, nil
+}
+
+func (s unstructuredScheme) ConvertFieldLabel(gvk schema.GroupVersionKind, label, value string) (string, string, error) {
+	return s.scheme.ConvertFieldLabel(gvk, label, value)
+}
+
+// toTyped converts the given unstructured Object to the type registered for its kind.
+func (s unstructuredScheme) toTyped(obj kruntime.Object) (kruntime.Object, error) {
+	u, ok := obj.(kruntime.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("expected an unstructured Object, got %T", obj)
+	}
+
+	typed, err := s.scheme.New(obj.GetObjectKind().GroupVersionKind())
+	if err != nil {
+		return nil, err
+	}
+	if err := kruntime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), typed); err != nil {
+		return nil, err
+	}
+	return typed, nil
+}
+
+// toJSONUnstructured converts obj to an unstructured Object holding JSON values only,
+// as structured-merge-diff doesn't support other types, e.g. uint64 fields.
+func toJSONUnstructured(obj kruntime.Object) (*unstructured.Unstructured, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	if err := utiljson.Unmarshal(data, &u.Object); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// pruneZeroValues removes the fields with zero values from content, recursively. Fields of typed
+// Objects can't be told apart from unset ones if they are zero, and would otherwise all be applied.
+// Lists are atomic, and kept as-is unless empty. See the docs of Storage.Apply for the consequences.
+func pruneZeroValues(content map[string]interface{}) {
+	for k, v := range content {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			pruneZeroValues(v)
+			if len(v) == 0 {
+				delete(content, k)
+			}
+		case []interface{}:
+			if len(v) == 0 {
+				delete(content, k)
+			}
+		case nil:
+			delete(content, k)
+		case string, bool, int64, float64:
+			if v == "" || v == false || v == int64(0) || v == float64(0) {
+				delete(content, k)
+			}
+		}
+	}
+}
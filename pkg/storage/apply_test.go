@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestGenericStorage_Apply(t *testing.T) {
+	s := newTestStorage(NewMemoryRawStorage(serializer.ContentTypeYAML))
+	key := NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/foo"))
+
+	newCar := func(brand, engine string) *v1alpha1.Car {
+		car := newTestCar("foo", brand)
+		car.Spec.Engine = engine
+		return car
+	}
+
+	tests := []struct {
+		name       string
+		car        *v1alpha1.Car
+		manager    string
+		force      bool
+		wantErr    error
+		wantBrand  string
+		wantEngine string
+	}{
+		{
+			name:      "apply creates the object",
+			car:       newCar("Volvo", ""),
+			manager:   "alice",
+			wantBrand: "Volvo",
+		},
+		{
+			name:       "other manager sets an unowned field",
+			car:        newCar("Volvo", "V8"),
+			manager:    "bob",
+			wantBrand:  "Volvo",
+			wantEngine: "V8",
+		},
+		{
+			name:       "shared field can't be changed by one owner",
+			car:        newCar("Saab", ""),
+			manager:    "alice",
+			wantErr:    ErrConflict, // bob owns spec.engine
+			wantBrand:  "Volvo",
+			wantEngine: "V8",
+		},
+		{
+			name:       "conflicting apply is rejected",
+			car:        newCar("Tesla", "V8"),
+			manager:    "bob",
+			wantErr:    ErrConflict,
+			wantBrand:  "Volvo",
+			wantEngine: "V8",
+		},
+		{
+			name:       "forced apply takes over the field",
+			car:        newCar("Tesla", "V8"),
+			manager:    "bob",
+			force:      true,
+			wantBrand:  "Tesla",
+			wantEngine: "V8",
+		},
+		{
+			name:       "former owner now conflicts",
+			car:        newCar("Volvo", "V8"),
+			manager:    "alice",
+			wantErr:    ErrConflict,
+			wantBrand:  "Tesla",
+			wantEngine: "V8",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Apply(tt.car, tt.manager, tt.force)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+
+			obj, err := s.Get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			car := obj.(*v1alpha1.Car)
+			if car.Spec.Brand != tt.wantBrand || car.Spec.Engine != tt.wantEngine {
+				t.Errorf("expected brand %q and engine %q, got %q and %q", tt.wantBrand, tt.wantEngine, car.Spec.Brand, car.Spec.Engine)
+			}
+		})
+	}
+
+	// Updates of applied objects are recorded too
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj.(*v1alpha1.Car).Spec.YearModel = "2020"
+	if err := s.Update(obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj, err = s.Get(key); err != nil {
+		t.Fatal(err)
+	}
+	var managers []string
+	for _, f := range obj.GetManagedFields() {
+		managers = append(managers, f.Manager)
+	}
+	if strings.Join(managers, ",") != "alice,bob,"+DefaultFieldManager {
+		t.Errorf("expected managers alice, bob and %s, got %v", DefaultFieldManager, managers)
+	}
+
+	// Objects which have never been applied have no managed fields
+	car := newTestCar("bar", "Volvo")
+	if err := s.Create(car); err != nil {
+		t.Fatal(err)
+	}
+	car.Spec.Brand = "Saab"
+	if err := s.Update(car); err != nil {
+		t.Fatal(err)
+	}
+	if len(car.GetManagedFields()) != 0 {
+		t.Errorf("expected no managed fields, got %v", car.GetManagedFields())
+	}
+}
+
+func TestGenericStorage_ApplyZeroValues(t *testing.T) {
+	s := newTestStorage(NewMemoryRawStorage(serializer.ContentTypeYAML))
+	key := NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/foo"))
+
+	// ownsEngine returns true if alice owns spec.engine
+	ownsEngine := func() bool {
+		t.Helper()
+		obj, err := s.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range obj.GetManagedFields() {
+			if f.Manager == "alice" && f.FieldsV1 != nil && strings.Contains(string(f.FieldsV1.Raw), `"f:engine"`) {
+				return true
+			}
+		}
+		return false
+	}
+
+	car := newTestCar("foo", "Volvo")
+	car.Spec.Engine = "V8"
+	if err := s.Apply(car, "alice", false); err != nil {
+		t.Fatal(err)
+	}
+	if !ownsEngine() {
+		t.Fatal("expected alice to own spec.engine")
+	}
+
+	// The zero value of a typed Object means unset, so applying it releases the field
+	if err := s.Apply(newTestCar("foo", "Volvo"), "alice", false); err != nil {
+		t.Fatal(err)
+	}
+	if ownsEngine() {
+		t.Error("expected alice to no longer own spec.engine after applying a typed zero value")
+	}
+
+	// Unstructured Objects set exactly the fields they contain, including zero values
+	u := runtime.NewUnstructured(&unstructured.Unstructured{})
+	u.SetGroupVersionKind(carGVK)
+	u.SetName("foo")
+	u.SetNamespace("default")
+	if err := unstructured.SetNestedField(u.Object, "Volvo", "spec", "brand"); err != nil {
+		t.Fatal(err)
+	}
+	if err := unstructured.SetNestedField(u.Object, "", "spec", "engine"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Apply(u, "alice", false); err != nil {
+		t.Fatal(err)
+	}
+	if !ownsEngine() {
+		t.Error("expected alice to own spec.engine after applying an unstructured zero value")
+	}
+}
@@ -1,24 +1,53 @@
 package cache
 
-/*
-
-TODO: Revisit if we need this file/package in the future.
-
 import (
-	log "github.com/sirupsen/logrus"
+	"context"
+	"errors"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/filter"
 	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
 	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
 	"github.com/save-abandoned-projects/libgitops/pkg/storage"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"github.com/save-abandoned-projects/libgitops/pkg/util"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
 )
 
-// Cache is an intermediate caching layer, which conforms to Storage
-// Typically you back the cache with an actual storage
+// Cache is an intermediate caching layer, which conforms to Storage.
+// Typically you back the cache with an actual storage.
 type Cache interface {
 	storage.Storage
-	// Flush is used to write the state of the entire cache to storage
-	// Warning: this is a very expensive operation
-	Flush() error
+	// Invalidate drops all cached Objects, they are loaded from the backing storage on the next access
+	Invalidate()
+}
+
+// CacheOptions configures the Cache.
+type CacheOptions struct {
+	// MaxObjects is the maximum amount of Objects kept in memory. When it is exceeded, the
+	// least recently used Objects are dropped from the cache. 0 means no limit. (Default: 0)
+	MaxObjects *int
+}
+
+type CacheOptionsFunc func(*CacheOptions)
+
+func WithMaxObjects(maxObjects int) CacheOptionsFunc {
+	return func(opts *CacheOptions) {
+		opts.MaxObjects = &maxObjects
+	}
+}
+
+func defaultCacheOpts() *CacheOptions {
+	return &CacheOptions{
+		MaxObjects: util.IntPtr(0),
+	}
+}
+
+func newCacheOpts(fns ...CacheOptionsFunc) *CacheOptions {
+	opts := defaultCacheOpts()
+	for _, fn := range fns {
+		fn(opts)
+	}
+	return opts
 }
 
 type cache struct {
@@ -26,172 +55,253 @@ type cache struct {
 	// used to look up non-cached Objects
 	storage storage.Storage
 
-	// index caches the Objects by GroupVersionKind and UID
-	// This guarantees uniqueness when looking up a specific Object
+	// index caches the decoded Objects by their ObjectKey,
+	// together with the checksum they were decoded at
 	index *index
 }
 
 var _ Cache = &cache{}
 
-func NewCache(backingStorage storage.Storage) Cache {
-	c := &cache{
+// NewCache creates a new Cache in front of the given Storage. Get, GetMeta, List, ListMeta, Walk and
+// Find are served from memory, as long as the Checksum of the cached Object matches the one in the
+// backing Storage. Otherwise the Object is decoded again, and the cache updated. Writes go straight
+// through to the backing Storage, invalidating the cached Object. The Cache is safe for concurrent use.
+func NewCache(backingStorage storage.Storage, optFns ...CacheOptionsFunc) Cache {
+	opts := newCacheOpts(optFns...)
+	return &cache{
 		storage: backingStorage,
-		index:   newIndex(backingStorage),
+		index:   newIndex(*opts.MaxObjects),
 	}
-
-	return c
 }
 
-func (s *cache) Serializer() serializer.Serializer {
-	return s.storage.Serializer()
+func (c *cache) Serializer() serializer.Serializer {
+	return c.storage.Serializer()
 }
 
-func (c *cache) New(gvk schema.GroupVersionKind) (runtime.Object, error) {
-	// Request the storage to create the Object. The
-	// newly generated Object has not got an UID which
-	// is required for indexing, so just return it
-	// without storing it into the cache
-	return c.storage.New(gvk)
+func (c *cache) Get(key storage.ObjectKey) (runtime.Object, error) {
+	obj, err := c.load(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return a copy, so the caller can't modify the cached Object
+	return obj.DeepCopyObject().(runtime.Object), nil
 }
 
-func (c *cache) Get(gvk schema.GroupVersionKind, uid runtime.UID) (obj runtime.Object, err error) {
-	log.Tracef("cache: Get %s with UID %q", gvk.Kind, uid)
+// load returns the cached Object for the given key, reloading it from the storage if
+// it isn't cached, or if its checksum has changed. The returned Object must not be modified.
+func (c *cache) load(key storage.ObjectKey) (runtime.Object, error) {
+	checksum, err := c.storage.Checksum(key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.index.delete(key)
+		}
+		return nil, err
+	}
 
 	// If the requested Object resides in the cache, return it
-	if obj, err = c.index.loadByID(gvk, uid); err != nil || obj != nil {
-		return
+	if obj := c.index.load(key, checksum); obj != nil {
+		return obj, nil
 	}
 
-	// Request the Object from the storage
-	obj, err = c.storage.Get(gvk, uid)
+	// Request the Object from the storage, and cache it. If the Object changed between
+	// the checksum and the read, the next load will notice the checksum mismatch.
+	obj, err := c.storage.Get(key)
+	if err != nil {
+		return nil, err
+	}
 
-	// If no errors occurred, cache it
-	if err == nil {
-		err = c.index.store(obj)
+	c.index.store(key, obj, checksum)
+	return obj, nil
+}
+
+func (c *cache) GetMeta(key storage.ObjectKey) (runtime.PartialObject, error) {
+	checksum, err := c.storage.Checksum(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Serve the metadata from the cached Object if possible, but don't
+	// load the full Object into the cache just for the metadata
+	if obj := c.index.load(key, checksum); obj != nil {
+		if partial, ok := partialObjectFrom(obj); ok {
+			return partial, nil
+		}
 	}
 
+	return c.storage.GetMeta(key)
+}
+
+func (c *cache) List(kind storage.KindKey, opts ...filter.ListOption) (result []runtime.Object, err error) {
+	err = c.Walk(context.Background(), kind, func(obj runtime.Object) error {
+		result = append(result, obj)
+		return nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
 	return
 }
 
-func (c *cache) GetMeta(gvk schema.GroupVersionKind, uid runtime.UID) (obj runtime.Object, err error) {
-	log.Tracef("cache: GetMeta %s with UID %q", gvk.Kind, uid)
+func (c *cache) Walk(ctx context.Context, kind storage.KindKey, fn storage.WalkFunc, opts ...filter.ListOption) error {
+	return c.walk(ctx, kind, opts, c.Get, fn)
+}
 
-	obj, err = c.storage.GetMeta(gvk, uid)
+func (c *cache) Find(kind storage.KindKey, opts ...filter.ListOption) (runtime.Object, error) {
+	// Do a normal list underneath
+	objs, err := c.List(kind, opts...)
+	if err != nil {
+		return nil, err
+	}
 
-	// If no errors occurred while loading, store the Object in the cache
-	if err == nil {
-		err = c.index.storeMeta(obj)
+	// Return based on the object count
+	switch l := len(objs); l {
+	case 0:
+		return nil, storage.ErrNotFound
+	case 1:
+		return objs[0], nil
+	default:
+		return nil, storage.ErrAmbiguousFind
 	}
+}
 
-	return
+func (c *cache) ListMeta(kind storage.KindKey, opts ...filter.ListOption) ([]runtime.PartialObject, error) {
+	var result []runtime.PartialObject
+	err := c.walk(context.Background(), kind, opts, func(key storage.ObjectKey) (runtime.Object, error) {
+		return c.GetMeta(key)
+	}, func(obj runtime.Object) error {
+		result = append(result, obj.(runtime.PartialObject))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-func (c *cache) Set(gvk schema.GroupVersionKind, obj runtime.Object) error {
-	log.Tracef("cache: Set %s with UID %q", gvk.Kind, obj.GetUID())
+// walk loads the Objects of the given kind in the same order as the backing storage lists them, pipes
+// them through the filters one-by-one, and calls fn for the ones left. The walk stops as soon as the
+// requested amount of Objects is found.
+func (c *cache) walk(ctx context.Context, kind storage.KindKey, opts []filter.ListOption, loadFn func(storage.ObjectKey) (runtime.Object, error), fn storage.WalkFunc) error {
+	o, err := filter.MakeListOptions(opts...)
+	if err != nil {
+		return err
+	}
 
-	// Store the changed Object in the cache
-	if err := c.index.store(obj); err != nil {
+	keys, err := storage.ListKeys(c.storage.RawStorage(), kind, o.Continue)
+	if err != nil {
 		return err
 	}
 
-	// TODO: For now the cache always flushes, we might add automatic flushing later
-	return c.storage.Set(gvk, obj)
+	var count int64
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		obj, err := loadFn(key)
+		if errors.Is(err, storage.ErrNotFound) {
+			// The Object was deleted after listing the keys
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		// For all list filters, pipe the output of the previous as the input to the next, in order.
+		objs := []runtime.Object{obj}
+		for _, filter := range o.Filters {
+			if objs, err = filter.Filter(objs...); err != nil {
+				return err
+			}
+		}
+
+		for _, obj := range objs {
+			if err := fn(obj); errors.Is(err, storage.ErrStopWalk) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			// Stop walking if we've got all the Objects that were asked for
+			count++
+			if o.Limit > 0 && count >= o.Limit {
+				return nil
+			}
+		}
+	}
+
+	return nil
 }
 
-func (c *cache) Patch(gvk schema.GroupVersionKind, uid runtime.UID, patch []byte) error {
-	// TODO: For now patches are always flushed, the cache will load the updated Object on-demand on access
-	return c.storage.Patch(gvk, uid, patch)
+func (c *cache) Create(obj runtime.Object) error {
+	return c.write(obj, c.storage.Create)
 }
 
-func (c *cache) Delete(gvk schema.GroupVersionKind, uid runtime.UID) error {
-	log.Tracef("cache: Delete %s with UID %q", gvk.Kind, uid)
+func (c *cache) Update(obj runtime.Object) error {
+	return c.write(obj, c.storage.Update)
+}
 
-	// Delete the given Object from the cache and storage
-	c.index.delete(gvk, uid)
-	return c.storage.Delete(gvk, uid)
+func (c *cache) UpdateStatus(obj runtime.Object) error {
+	return c.write(obj, c.storage.UpdateStatus)
 }
 
-type listFunc func(gvk schema.GroupVersionKind) ([]runtime.Object, error)
-type cacheStoreFunc func([]runtime.Object) error
+func (c *cache) StatusSubresource() bool {
+	return c.storage.StatusSubresource()
+}
 
-// list is a common handler for List and ListMeta
-func (c *cache) list(gvk schema.GroupVersionKind, slf, clf listFunc, csf cacheStoreFunc) (objs []runtime.Object, err error) {
-	var storageCount uint64
-	if storageCount, err = c.storage.Count(gvk); err != nil {
-		return
-	}
+func (c *cache) Apply(obj runtime.Object, fieldManager string, force bool) error {
+	return c.write(obj, func(obj runtime.Object) error {
+		return c.storage.Apply(obj, fieldManager, force)
+	})
+}
 
-	if c.index.count(gvk) != storageCount {
-		log.Tracef("cache: miss when listing: %s", gvk)
-		// If the cache doesn't track all of the Objects, request them from the storage
-		if objs, err = slf(gvk); err != nil {
-			// If no errors occurred, store the Objects in the cache
-			err = csf(objs)
-		}
-	} else {
-		log.Tracef("cache: hit when listing: %s", gvk)
-		// If the cache tracks everything, return the cache's contents
-		objs, err = clf(gvk)
+// write invalidates the cached Object after writing it using writeFn
+func (c *cache) write(obj runtime.Object, writeFn func(runtime.Object) error) error {
+	key, err := c.storage.ObjectKeyFor(obj)
+	if err != nil {
+		return err
 	}
 
-	return
+	log.Tracef("cache: write %s", key)
+	defer c.index.delete(key)
+	return writeFn(obj)
 }
 
-func (c *cache) List(gvk schema.GroupVersionKind) ([]runtime.Object, error) {
-	return c.list(gvk, c.storage.List, c.index.list, c.index.storeAll)
+func (c *cache) Patch(key storage.ObjectKey, patchType types.PatchType, patch []byte) error {
+	log.Tracef("cache: Patch %s", key)
+	defer c.index.delete(key)
+	return c.storage.Patch(key, patchType, patch)
 }
 
-func (c *cache) ListMeta(gvk schema.GroupVersionKind) ([]runtime.Object, error) {
-	return c.list(gvk, c.storage.ListMeta, c.index.listMeta, c.index.storeAllMeta)
+func (c *cache) Delete(key storage.ObjectKey, opts ...storage.DeleteOption) error {
+	log.Tracef("cache: Delete %s", key)
+	defer c.index.delete(key)
+	return c.storage.Delete(key, opts...)
 }
 
-func (c *cache) Count(gvk schema.GroupVersionKind) (uint64, error) {
+func (c *cache) Count(kind storage.KindKey) (uint64, error) {
 	// The cache is transparent about how many items it has cached
-	return c.storage.Count(gvk)
+	return c.storage.Count(kind)
 }
 
-func (c *cache) Checksum(gvk schema.GroupVersionKind, uid runtime.UID) (string, error) {
+func (c *cache) Checksum(key storage.ObjectKey) (string, error) {
 	// The cache is transparent about the checksums
-	return c.storage.Checksum(gvk, uid)
+	return c.storage.Checksum(key)
 }
 
 func (c *cache) RawStorage() storage.RawStorage {
 	return c.storage.RawStorage()
 }
 
-func (c *cache) Close() error {
-	return c.storage.Close()
+func (c *cache) ObjectKeyFor(obj runtime.Object) (storage.ObjectKey, error) {
+	return c.storage.ObjectKeyFor(obj)
 }
 
-func (c *cache) Flush() error {
-	// Load the entire cache
-	allObjects, err := c.index.loadAll()
-	if err != nil {
-		return err
-	}
-
-	for _, obj := range allObjects {
-		// Request the storage to save each Object
-		if err := c.storage.Set(obj); err != nil {
-			return err
-		}
-	}
-
-	return nil
+func (c *cache) Invalidate() {
+	c.index.reset()
 }
 
-// PartialObjectFrom is used to create a bound PartialObjectImpl from an Object.
-// Note: This might be useful later (maybe here or maybe in pkg/runtime) if re-enable the cache
-func PartialObjectFrom(obj Object) (PartialObject, error) {
-	tm, ok := obj.GetObjectKind().(*metav1.TypeMeta)
-	if !ok {
-		return nil, fmt.Errorf("PartialObjectFrom: Cannot cast obj to *metav1.TypeMeta, is %T", obj.GetObjectKind())
-	}
-	om, ok := obj.GetObjectMeta().(*metav1.ObjectMeta)
-	if !ok {
-		return nil, fmt.Errorf("PartialObjectFrom: Cannot cast obj to *metav1.ObjectMeta, is %T", obj.GetObjectMeta())
-	}
-	return &PartialObjectImpl{tm, om}, nil
+func (c *cache) Close() error {
+	c.index.reset()
+	return c.storage.Close()
 }
-
-*/
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+	"github.com/save-abandoned-projects/libgitops/pkg/storage"
+)
+
+var carKind = storage.NewKindKey(v1alpha1.SchemeGroupVersion.WithKind("Car"))
+
+// countingStorage counts the Get and GetMeta calls reaching the backing Storage
+type countingStorage struct {
+	storage.Storage
+	gets, getMetas int
+}
+
+func (s *countingStorage) Get(key storage.ObjectKey) (runtime.Object, error) {
+	s.gets++
+	return s.Storage.Get(key)
+}
+
+func (s *countingStorage) GetMeta(key storage.ObjectKey) (runtime.PartialObject, error) {
+	s.getMetas++
+	return s.Storage.GetMeta(key)
+}
+
+func newTestCar(name, brand string) *v1alpha1.Car {
+	car := &v1alpha1.Car{}
+	car.SetGroupVersionKind(carKind.GetGVK())
+	car.Name = name
+	car.Namespace = "default"
+	car.Spec.Brand = brand
+	return car
+}
+
+func newTestCache(t *testing.T, optFns ...CacheOptionsFunc) (Cache, *countingStorage) {
+	backing := &countingStorage{
+		Storage: storage.NewGenericStorage(
+			storage.NewMemoryRawStorage(serializer.ContentTypeYAML),
+			scheme.Serializer,
+			[]runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+		),
+	}
+	c := NewCache(backing, optFns...)
+	for _, name := range []string{"a", "b", "c"} {
+		if err := c.Create(newTestCar(name, "Volvo")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c, backing
+}
+
+func TestCache(t *testing.T) {
+	c, backing := newTestCache(t)
+	key := storage.NewObjectKey(carKind, runtime.NewIdentifier("default/a"))
+
+	if objs, err := c.List(carKind); err != nil || len(objs) != 3 {
+		t.Fatalf("List() = %v, %v, want 3 objects", objs, err)
+	}
+	if backing.gets != 3 {
+		t.Errorf("expected 3 Gets on the first List, got %d", backing.gets)
+	}
+
+	// The second List, and the metadata, is served from memory
+	if _, err := c.List(carKind); err != nil {
+		t.Fatal(err)
+	}
+	metas, err := c.ListMeta(carKind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backing.gets != 3 || backing.getMetas != 0 {
+		t.Errorf("expected the cache to be hit, got %d Gets and %d GetMetas", backing.gets, backing.getMetas)
+	}
+	if len(metas) != 3 || metas[0].GetName() != "a" || metas[0].GetObjectKind().GroupVersionKind() != carKind.GetGVK() {
+		t.Errorf("unexpected ListMeta() result %v", metas)
+	}
+
+	// Modifying a returned Object must not modify the cache
+	obj, err := c.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj.(*v1alpha1.Car).Spec.Brand = "Saab"
+	if obj, err = c.Get(key); err != nil || obj.(*v1alpha1.Car).Spec.Brand != "Volvo" {
+		t.Errorf("expected the cached Object to be unmodified, got %v, %v", obj, err)
+	}
+
+	// Changes made directly to the backing Storage are noticed through the checksum
+	if err := backing.Update(newTestCar("a", "Saab")); err != nil {
+		t.Fatal(err)
+	}
+	if obj, err = c.Get(key); err != nil || obj.(*v1alpha1.Car).Spec.Brand != "Saab" {
+		t.Errorf("expected the Object to be reloaded, got %v, %v", obj, err)
+	}
+	if backing.gets != 4 {
+		t.Errorf("expected exactly one reload, got %d Gets", backing.gets)
+	}
+
+	if err := c.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestCache_MaxObjects(t *testing.T) {
+	c, backing := newTestCache(t, WithMaxObjects(2))
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.List(carKind); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := c.(*cache).index.count(); count != 2 {
+		t.Errorf("expected 2 cached objects, got %d", count)
+	}
+	// With the least recently used Object dropped first, each List reloads everything
+	if backing.gets != 6 {
+		t.Errorf("expected 6 Gets, got %d", backing.gets)
+	}
+
+	c.Invalidate()
+	if count := c.(*cache).index.count(); count != 0 {
+		t.Errorf("expected an empty cache after Invalidate, got %d objects", count)
+	}
+}
@@ -1,156 +1,105 @@
 package cache
 
-/*
-
-TODO: Revisit if we need this file/package in the future.
-
 import (
-	log "github.com/sirupsen/logrus"
+	"container/list"
+	"sync"
+
 	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
 	"github.com/save-abandoned-projects/libgitops/pkg/storage"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	log "github.com/sirupsen/logrus"
 )
 
+// index holds the cached Objects, and drops the least recently used
+// ones when more than maxObjects are stored. It is safe for concurrent use.
 type index struct {
-	storage storage.Storage
-	objects map[schema.GroupVersionKind]map[runtime.UID]*cacheObject
+	maxObjects int
+	// objects maps the String() of the ObjectKeys to their elements in lru
+	objects map[string]*list.Element
+	// lru holds the *cacheObjects, the most recently used one first
+	lru *list.List
+	mux *sync.Mutex
 }
 
-func newIndex(storage storage.Storage) *index {
+func newIndex(maxObjects int) *index {
 	return &index{
-		storage: storage,
-		objects: make(map[schema.GroupVersionKind]map[runtime.UID]*cacheObject),
+		maxObjects: maxObjects,
+		objects:    make(map[string]*list.Element),
+		lru:        list.New(),
+		mux:        &sync.Mutex{},
 	}
 }
 
-func (i *index) loadByID(gvk schema.GroupVersionKind, uid runtime.UID) (runtime.Object, error) {
-	if uids, ok := i.objects[gvk]; ok {
-		if obj, ok := uids[uid]; ok {
-			log.Tracef("index: cache hit for %s with UID %q", gvk.Kind, uid)
-			return obj.loadFull()
-		}
-	}
+// load returns the cached Object for the given key, if it was cached at the given checksum.
+// An Object cached at another checksum is outdated, and dropped from the cache.
+func (i *index) load(key storage.ObjectKey, checksum string) runtime.Object {
+	i.mux.Lock()
+	defer i.mux.Unlock()
 
-	log.Tracef("index: cache miss for %s with UID %q", gvk.Kind, uid)
-	return nil, nil
-}
-
-func (i *index) loadAll() ([]runtime.Object, error) {
-	var size uint64
-
-	for gvk := range i.objects {
-		size += i.count(gvk)
+	elem, ok := i.objects[key.String()]
+	if !ok {
+		log.Tracef("index: cache miss for %s", key)
+		return nil
 	}
 
-	all := make([]runtime.Object, 0, size)
-
-	for gvk := range i.objects {
-		if objects, err := i.list(gvk); err == nil {
-			all = append(all, objects...)
-		} else {
-			return nil, err
-		}
+	co := elem.Value.(*cacheObject)
+	if co.checksum != checksum {
+		log.Tracef("index: %s invalidated, checksum mismatch: %q -> %q", key, co.checksum, checksum)
+		i.remove(elem)
+		return nil
 	}
 
-	return all, nil
+	log.Tracef("index: cache hit for %s", key)
+	i.lru.MoveToFront(elem)
+	return co.object
 }
 
-func store(i *index, obj runtime.Object, apiType bool) error {
-	// If store is called for an invalid Object lacking an UID,
-	// panic and print the stack trace. This should never happen.
-	if obj.GetUID() == "" {
-		panic("Attempt to cache invalid Object: missing UID")
-	}
-
-	co, err := newCacheObject(i.storage, obj, apiType)
-	if err != nil {
-		return err
-	}
-
-	gvk := co.object.GetObjectKind().GroupVersionKind()
+// store caches the given Object for the given key at the given checksum
+func (i *index) store(key storage.ObjectKey, obj runtime.Object, checksum string) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
 
-	if _, ok := i.objects[gvk]; !ok {
-		i.objects[gvk] = make(map[runtime.UID]*cacheObject)
+	co := &cacheObject{key: key.String(), object: obj, checksum: checksum}
+	if elem, ok := i.objects[co.key]; ok {
+		elem.Value = co
+		i.lru.MoveToFront(elem)
+		return
 	}
 
-	log.Tracef("index: storing %s object with UID %q, meta: %t", gvk.Kind, obj.GetName(), apiType)
-	i.objects[gvk][co.object.GetUID()] = co
-
-	return nil
-}
+	log.Tracef("index: storing %s with checksum %q", key, checksum)
+	i.objects[co.key] = i.lru.PushFront(co)
 
-func (i *index) store(obj runtime.Object) error {
-	return store(i, obj, false)
-}
-
-func (i *index) storeAll(objs []runtime.Object) (err error) {
-	for _, obj := range objs {
-		if err = i.store(obj); err != nil {
-			break
-		}
+	// Drop the least recently used Objects, if the cache is full
+	for i.maxObjects > 0 && i.lru.Len() > i.maxObjects {
+		i.remove(i.lru.Back())
 	}
-
-	return
 }
 
-func (i *index) storeMeta(obj runtime.Object) error {
-	return store(i, obj, true)
-}
+func (i *index) delete(key storage.ObjectKey) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
 
-func (i *index) storeAllMeta(objs []runtime.Object) (err error) {
-	for _, obj := range objs {
-		if uids, ok := i.objects[obj.GetObjectKind().GroupVersionKind()]; ok {
-			if _, ok := uids[obj.GetUID()]; ok {
-				continue
-			}
-		}
-
-		if err = i.storeMeta(obj); err != nil {
-			break
-		}
+	if elem, ok := i.objects[key.String()]; ok {
+		i.remove(elem)
 	}
-
-	return
 }
 
-func (i *index) delete(gvk schema.GroupVersionKind, uid runtime.UID) {
-	if uids, ok := i.objects[gvk]; ok {
-		delete(uids, uid)
-	}
-}
+func (i *index) count() int {
+	i.mux.Lock()
+	defer i.mux.Unlock()
 
-func (i *index) count(gvk schema.GroupVersionKind) (count uint64) {
-	count = uint64(len(i.objects[gvk]))
-	log.Tracef("index: counted %d %s object(s)", count, gvk.Kind)
-	return
+	return i.lru.Len()
 }
 
-func list(i *index, gvk schema.GroupVersionKind, apiTypes bool) ([]runtime.Object, error) {
-	uids := i.objects[gvk]
-	list := make([]runtime.Object, 0, len(uids))
-
-	log.Tracef("index: listing %s objects, meta: %t", gvk, apiTypes)
-	for _, obj := range uids {
-		loadFunc := obj.loadFull
-		if apiTypes {
-			loadFunc = obj.loadAPI
-		}
-
-		if result, err := loadFunc(); err != nil {
-			return nil, err
-		} else {
-			list = append(list, result)
-		}
-	}
-
-	return list, nil
-}
+func (i *index) reset() {
+	i.mux.Lock()
+	defer i.mux.Unlock()
 
-func (i *index) list(gvk schema.GroupVersionKind) ([]runtime.Object, error) {
-	return list(i, gvk, false)
+	i.objects = make(map[string]*list.Element)
+	i.lru.Init()
 }
 
-func (i *index) listMeta(gvk schema.GroupVersionKind) ([]runtime.Object, error) {
-	return list(i, gvk, true)
+// remove drops the given element, the caller must hold the lock
+func (i *index) remove(elem *list.Element) {
+	delete(i.objects, elem.Value.(*cacheObject).key)
+	i.lru.Remove(elem)
 }
-*/
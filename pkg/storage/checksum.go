@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// ChecksumStrategy computes the checksum returned by RawStorage.Checksum for files on disk.
+type ChecksumStrategy interface {
+	// Checksum returns a string checksum for the file at the given path.
+	Checksum(path string) (string, error)
+}
+
+var (
+	// ModTimeChecksum uses the modification time of the file, in nanoseconds, as the checksum.
+	// It is cheap to compute, but changes on every git checkout, and might miss edits made
+	// within the same clock tick.
+	ModTimeChecksum ChecksumStrategy = modTimeChecksum{}
+	// SHA256Checksum uses the hex-encoded SHA-256 hash of the file content as the checksum.
+	// The checksum is stable across restarts and clones.
+	SHA256Checksum ChecksumStrategy = sha256Checksum{}
+	// GitBlobChecksum uses the git blob hash (as given by "git hash-object") of the file content
+	// as the checksum. The checksum matches what git stores for the file in a git-backed directory.
+	GitBlobChecksum ChecksumStrategy = gitBlobChecksum{}
+)
+
+type modTimeChecksum struct{}
+
+func (modTimeChecksum) Checksum(path string) (string, error) {
+	return checksumFromModTime(path)
+}
+
+type sha256Checksum struct{}
+
+func (sha256Checksum) Checksum(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type gitBlobChecksum struct{}
+
+func (gitBlobChecksum) Checksum(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	// Git hashes the content prefixed by a "blob <size>\0" header
+	h := sha1.New()
+	_, _ = fmt.Fprintf(h, "blob %d\x00", len(content))
+	_, _ = h.Write(content)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func checksumFromModTime(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(fi.ModTime().UnixNano(), 10), nil
+}
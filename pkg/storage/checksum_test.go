@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksumStrategies(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "metadata.yaml")
+	if err := ioutil.WriteFile(file, []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		strategy ChecksumStrategy
+		want     string
+	}{
+		{
+			name:     "sha256",
+			strategy: SHA256Checksum,
+			want:     "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03",
+		},
+		{
+			name:     "git blob",
+			strategy: GitBlobChecksum,
+			want:     "ce013625030ba8dba906f756967f9e9ca394464a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.strategy.Checksum(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Checksum() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
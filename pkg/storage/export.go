@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"time"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+	log "github.com/sirupsen/logrus"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// Export writes the Objects of all kinds registered in the scheme of the Storage to the given FrameWriter,
+// one frame per Object, e.g. as a multi-document YAML stream. The Objects are written in the version they
+// are stored in, ordered by kind and key, and the comments of YAML content are kept. The resourceVersions
+// aren't exported, as they are specific to the Storage. Use Import to read the Objects back.
+func Export(ctx context.Context, s Storage, fw serializer.FrameWriter) error {
+	encoder := s.Serializer().Encoder(serializer.WithCommentsEncode(true))
+	return exportObjects(ctx, s, func(_ ObjectKey, obj kruntime.Object) error {
+		return encoder.Encode(fw, obj)
+	})
+}
+
+// ExportTar is like Export, but writes a tar archive holding one file per Object. The path of each file is
+// given by the PathStrategy, e.g. KindNamespaceNamePathStrategy mirrors the directory layout of the storages
+// created by transaction.NewGitStorage and watch.NewManifestStorage. The extension of the path determines
+// the content type of the file, see ContentTypes. Use ImportTar to read the Objects back.
+func ExportTar(ctx context.Context, s Storage, w io.Writer, paths PathStrategy) error {
+	encoder := s.Serializer().Encoder(serializer.WithCommentsEncode(true))
+	modTime := time.Now()
+
+	tw := tar.NewWriter(w)
+	err := exportObjects(ctx, s, func(key ObjectKey, obj kruntime.Object) error {
+		p, err := paths.Path(key)
+		if err != nil {
+			return err
+		}
+		ct, ok := ContentTypes[path.Ext(p)]
+		if !ok {
+			return fmt.Errorf("unsupported file extension for %q", p)
+		}
+
+		var content bytes.Buffer
+		if err := encoder.Encode(serializer.NewFrameWriter(ct, &content), obj); err != nil {
+			return err
+		}
+
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     p,
+			Mode:     0644,
+			Size:     int64(content.Len()),
+			ModTime:  modTime,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = tw.Write(content.Bytes())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// exportObjects decodes the Objects of all kinds registered in the scheme of the Storage from their stored
+// content, keeping the comments of YAML content, and calls fn for each of them. All kinds are listed before
+// fn is first called, so listing errors don't leave a partial export behind.
+func exportObjects(ctx context.Context, s Storage, fn func(key ObjectKey, obj kruntime.Object) error) error {
+	raw := s.RawStorage()
+	decoder := s.Serializer().Decoder(serializer.WithCommentsDecode(true))
+
+	var keys []ObjectKey
+	for _, gvks := range objectKinds(s.Serializer().Scheme()) {
+		kindKeys, err := listAllVersions(raw, gvks)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, kindKeys...)
+	}
+
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		content, err := raw.Read(key)
+		if err != nil {
+			return err
+		}
+		ct := raw.ContentType(key)
+		if len(ct) == 0 {
+			ct = serializer.ContentTypeJSON
+		}
+
+		obj, err := decoder.Decode(serializer.NewFrameReader(ct, serializer.FromBytes(content)))
+		if err != nil {
+			return fmt.Errorf("couldn't decode %s: %w", key, err)
+		}
+
+		log.Debugf("Export: Exporting %s", key)
+		if err := fn(key, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportAction describes what Import did, or would do for a dry run, with an Object.
+type ImportAction string
+
+const (
+	// ImportActionCreate means that the Object didn't exist, and was created
+	ImportActionCreate ImportAction = "Create"
+	// ImportActionUpdate means that the Object existed, and was overwritten
+	ImportActionUpdate ImportAction = "Update"
+	// ImportActionSkip means that the Object existed, and was left as-is
+	ImportActionSkip ImportAction = "Skip"
+)
+
+// ImportResult is the result of importing one Object using Import or ImportTar.
+type ImportResult struct {
+	// Key is the key of the imported Object
+	Key ObjectKey
+	// Action is what was done with the Object
+	Action ImportAction
+	// Err is set if the Object couldn't be imported
+	Err error
+}
+
+// Import reads all frames from the given FrameReader, e.g. a stream written by Export, and writes the decoded
+// Objects to the Storage. New Objects are created, existing ones are handled according to the ImportOptions.
+// All frames are decoded before anything is written, so a stream which can't be decoded is rejected as a whole.
+// Failing to write an Object doesn't stop the import, the error is set in its result.
+func Import(ctx context.Context, s Storage, fr serializer.FrameReader, optFns ...ImportOptionsFunc) ([]ImportResult, error) {
+	opts := newImportOpts(optFns...)
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	objs, err := s.Serializer().Decoder(serializer.WithCommentsDecode(true)).DecodeAll(fr)
+	if err != nil {
+		return nil, err
+	}
+
+	return importObjects(ctx, s, objs, opts)
+}
+
+// ImportTar is like Import, but reads the Objects from the files of a tar archive, e.g. one written by ExportTar.
+// Files without an extension listed in ContentTypes are ignored.
+func ImportTar(ctx context.Context, s Storage, r io.Reader, optFns ...ImportOptionsFunc) ([]ImportResult, error) {
+	opts := newImportOpts(optFns...)
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	decoder := s.Serializer().Decoder(serializer.WithCommentsDecode(true))
+	var objs []kruntime.Object
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		ct, ok := ContentTypes[path.Ext(hdr.Name)]
+		if hdr.Typeflag != tar.TypeReg || !ok {
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		fileObjs, err := decoder.DecodeAll(serializer.NewFrameReader(ct, serializer.FromBytes(content)))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't decode %q: %w", hdr.Name, err)
+		}
+		objs = append(objs, fileObjs...)
+	}
+
+	return importObjects(ctx, s, objs, opts)
+}
+
+// validate returns an error if the options can't be combined.
+func (o *ImportOptions) validate() error {
+	if *o.Overwrite && *o.SkipExisting {
+		return fmt.Errorf("the Overwrite and SkipExisting import options can't be combined")
+	}
+	return nil
+}
+
+// importObjects writes the given Objects to the Storage, in order.
+func importObjects(ctx context.Context, s Storage, objs []kruntime.Object, opts *ImportOptions) ([]ImportResult, error) {
+	metaObjs := make([]runtime.Object, 0, len(objs))
+	for _, obj := range objs {
+		metaObj, ok := obj.(runtime.Object)
+		if !ok {
+			return nil, fmt.Errorf("can't convert %T to libgitops.runtime.Object", obj)
+		}
+		metaObjs = append(metaObjs, metaObj)
+	}
+
+	results := make([]ImportResult, 0, len(metaObjs))
+	for _, obj := range metaObjs {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		results = append(results, importObject(s, obj, opts))
+	}
+	return results, nil
+}
+
+// importObject creates or updates the given Object, unless opts.DryRun is set.
+func importObject(s Storage, obj runtime.Object, opts *ImportOptions) ImportResult {
+	key, err := s.ObjectKeyFor(obj)
+	if err != nil {
+		return ImportResult{Err: err}
+	}
+	result := ImportResult{Key: key, Action: ImportActionCreate}
+
+	// The resourceVersion of the exporting Storage doesn't apply
+	obj.SetResourceVersion("")
+
+	if s.RawStorage().Exists(key) {
+		switch {
+		case *opts.SkipExisting:
+			result.Action = ImportActionSkip
+			return result
+		case *opts.Overwrite:
+			result.Action = ImportActionUpdate
+		default:
+			result.Err = fmt.Errorf("%s: %w", key, ErrAlreadyExists)
+			return result
+		}
+	}
+
+	if *opts.DryRun {
+		return result
+	}
+
+	log.Debugf("Import: %s %s", result.Action, key)
+	if result.Action == ImportActionUpdate {
+		result.Err = s.Update(obj)
+	} else {
+		result.Err = s.Create(obj)
+	}
+	return result
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	fooKey := NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/foo"))
+	fooContent := `# The car of foo
+apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: foo
+  namespace: default
+spec:
+  brand: Volvo # Swedish
+`
+
+	raw := NewMemoryRawStorage(serializer.ContentTypeYAML)
+	if err := raw.Write(fooKey, []byte(fooContent)); err != nil {
+		t.Fatal(err)
+	}
+	src := newTestStorage(raw)
+	if err := src.Create(newTestCar("bar", "Tesla")); err != nil {
+		t.Fatal(err)
+	}
+
+	var stream bytes.Buffer
+	if err := Export(ctx, src, serializer.NewYAMLFrameWriter(&stream)); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# The car of foo\n", "brand: Volvo # Swedish\n", "brand: Tesla\n"} {
+		if !strings.Contains(stream.String(), want) {
+			t.Errorf("expected %q in the exported stream:\n%s", want, stream.String())
+		}
+	}
+	if strings.Contains(stream.String(), "resourceVersion") {
+		t.Errorf("expected no resourceVersion in the exported stream:\n%s", stream.String())
+	}
+
+	importStream := func(s Storage, optFns ...ImportOptionsFunc) []ImportResult {
+		t.Helper()
+		results, err := Import(ctx, s, serializer.NewYAMLFrameReader(serializer.FromBytes(stream.Bytes())), optFns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+	expectActions := func(results []ImportResult, action ImportAction, wantErr error) {
+		t.Helper()
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results, got %v", results)
+		}
+		for _, r := range results {
+			if r.Action != action {
+				t.Errorf("%s: expected action %s, got %s", r.Key, action, r.Action)
+			}
+			if !errors.Is(r.Err, wantErr) {
+				t.Errorf("%s: expected error %v, got %v", r.Key, wantErr, r.Err)
+			}
+		}
+	}
+
+	dstRaw := NewMemoryRawStorage(serializer.ContentTypeYAML)
+	dst := newTestStorage(dstRaw)
+
+	// A dry run doesn't write anything
+	expectActions(importStream(dst, WithDryRun(true)), ImportActionCreate, nil)
+	if count, err := dst.Count(NewKindKey(carGVK)); err != nil || count != 0 {
+		t.Fatalf("expected no Cars after a dry run, got %d (%v)", count, err)
+	}
+
+	expectActions(importStream(dst), ImportActionCreate, nil)
+	content, err := dstRaw.Read(fooKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# The car of foo\n", "brand: Volvo # Swedish\n"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("expected %q in the imported content:\n%s", want, content)
+		}
+	}
+
+	expectActions(importStream(dst), ImportActionCreate, ErrAlreadyExists)
+	expectActions(importStream(dst, WithSkipExisting(true)), ImportActionSkip, nil)
+	expectActions(importStream(dst, WithOverwrite(true)), ImportActionUpdate, nil)
+
+	if _, err := Import(ctx, dst, serializer.NewYAMLFrameReader(serializer.FromBytes(stream.Bytes())), WithOverwrite(true), WithSkipExisting(true)); err == nil {
+		t.Error("expected an error for combining Overwrite and SkipExisting")
+	}
+}
+
+func TestExportImportTar(t *testing.T) {
+	ctx := context.Background()
+	src := newTestStorage(NewMemoryRawStorage(serializer.ContentTypeJSON))
+	if err := src.Create(newTestCar("foo", "Volvo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Create(newTestCar("bar", "Tesla")); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	if err := ExportTar(ctx, src, &archive, KindNamespaceNamePathStrategy); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(archive.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if want := "Car/default/bar.yaml,Car/default/foo.yaml"; strings.Join(names, ",") != want {
+		t.Errorf("expected files %s, got %v", want, names)
+	}
+
+	dst := newTestStorage(NewMemoryRawStorage(serializer.ContentTypeYAML))
+	results, err := ImportTar(ctx, dst, bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %v", results)
+	}
+	for _, r := range results {
+		if r.Action != ImportActionCreate || r.Err != nil {
+			t.Errorf("expected %s to be created, got %+v", r.Key, r)
+		}
+	}
+
+	obj, err := dst.Get(NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/foo")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brand := obj.(*v1alpha1.Car).Spec.Brand; brand != "Volvo" {
+		t.Errorf("expected brand Volvo, got %q", brand)
+	}
+}
+
+func TestExportImport_GenericRawStorage(t *testing.T) {
+	ctx := context.Background()
+	// Only Cars are stored, the other kinds of the scheme have no directories
+	src := newTestStorage(NewGenericRawStorage(t.TempDir(), carGVK.GroupVersion(), serializer.ContentTypeYAML))
+	if err := src.Create(newTestCar("foo", "Volvo")); err != nil {
+		t.Fatal(err)
+	}
+
+	var stream bytes.Buffer
+	if err := Export(ctx, src, serializer.NewYAMLFrameWriter(&stream)); err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err := ExportTar(ctx, src, &archive, KindNamespaceNamePathStrategy); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newTestStorage(NewGenericRawStorage(t.TempDir(), carGVK.GroupVersion(), serializer.ContentTypeYAML))
+	results, err := Import(ctx, dst, serializer.NewYAMLFrameReader(serializer.FromBytes(stream.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Action != ImportActionCreate || results[0].Err != nil {
+		t.Fatalf("expected foo to be created, got %+v", results)
+	}
+
+	results, err = ImportTar(ctx, dst, bytes.NewReader(archive.Bytes()), WithSkipExisting(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Action != ImportActionSkip || results[0].Err != nil {
+		t.Fatalf("expected foo to be skipped, got %+v", results)
+	}
+
+	obj, err := dst.Get(NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/foo")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brand := obj.(*v1alpha1.Car).Spec.Brand; brand != "Volvo" {
+		t.Errorf("expected brand Volvo, got %q", brand)
+	}
+}
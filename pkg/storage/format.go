@@ -1,6 +1,10 @@
 package storage
 
-import "github.com/save-abandoned-projects/libgitops/pkg/serializer"
+import (
+	"sort"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+)
 
 // ContentTypes describes the connection between
 // file extensions and a content types.
@@ -10,11 +14,19 @@ var ContentTypes = map[string]serializer.ContentType{
 	".yml":  serializer.ContentTypeYAML,
 }
 
+// extForContentType returns the extension for the given content type. If there
+// are multiple, the alphabetically first one is returned, e.g. ".yaml" for YAML.
 func extForContentType(wanted serializer.ContentType) string {
+	exts := make([]string, 0, len(ContentTypes))
 	for ext, ct := range ContentTypes {
 		if ct == wanted {
-			return ext
+			exts = append(exts, ext)
 		}
 	}
-	return ""
+	if len(exts) == 0 {
+		return ""
+	}
+
+	sort.Strings(exts)
+	return exts[0]
 }
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ErrUnsupportedGroupVersion is returned by a RawStorageLayout for kinds of a GroupVersion it can't store.
+var ErrUnsupportedGroupVersion = errors.New("GroupVersion not supported by this RawStorageLayout")
+
+// coreGroupDir is the directory name used for the core (empty) API group,
+// as a path segment can't be empty.
+const coreGroupDir = "core"
+
+// RawStorageLayout describes where on disk GenericRawStorage stores the
+// objects of a given kind, relative to the root directory of the storage.
+type RawStorageLayout interface {
+	// KindPath returns the directory for the given kind, relative to the storage root.
+	// If the layout can't store the given kind, an error is returned.
+	KindPath(kind KindKey) (string, error)
+	// KindForPath is the reverse of KindPath. It parses the kind from the given path
+	// segments (relative to the storage root), and returns the segments that are left.
+	KindForPath(segments []string) (KindKey, []string, error)
+}
+
+// NewKindLayout returns a RawStorageLayout storing objects in the form <kind>/. Only one
+// GroupVersion is supported by this layout, any other kinds are rejected.
+func NewKindLayout(gv schema.GroupVersion) RawStorageLayout {
+	return kindLayout{gv}
+}
+
+// kindLayout implements RawStorageLayout.
+type kindLayout struct {
+	gv schema.GroupVersion
+}
+
+func (l kindLayout) KindPath(kind KindKey) (string, error) {
+	if l.gv.Group != kind.GetGroup() || l.gv.Version != kind.GetVersion() {
+		return "", fmt.Errorf("%s/%s: %w", kind.GetGroup(), kind.GetVersion(), ErrUnsupportedGroupVersion)
+	}
+
+	return kind.GetKind(), nil
+}
+
+func (l kindLayout) KindForPath(segments []string) (KindKey, []string, error) {
+	if len(segments) < 1 {
+		return nil, nil, fmt.Errorf("path not long enough: %s", path.Join(segments...))
+	}
+
+	return NewKindKey(l.gv.WithKind(segments[0])), segments[1:], nil
+}
+
+// GroupVersionKindLayout is a RawStorageLayout storing objects in the form <group>/<version>/<kind>/.
+// Objects of the core (empty) API group are stored in the "core" directory. This layout supports
+// any number of GroupVersions, so one GenericRawStorage can hold every kind in a scheme.
+var GroupVersionKindLayout RawStorageLayout = groupVersionKindLayout{}
+
+// groupVersionKindLayout implements RawStorageLayout.
+type groupVersionKindLayout struct{}
+
+func (groupVersionKindLayout) KindPath(kind KindKey) (string, error) {
+	group := kind.GetGroup()
+	if len(group) == 0 {
+		group = coreGroupDir
+	}
+
+	return path.Join(group, kind.GetVersion(), kind.GetKind()), nil
+}
+
+func (groupVersionKindLayout) KindForPath(segments []string) (KindKey, []string, error) {
+	if len(segments) < 3 {
+		return nil, nil, fmt.Errorf("path not long enough: %s", path.Join(segments...))
+	}
+
+	group := segments[0]
+	if group == coreGroupDir {
+		group = ""
+	}
+	gvk := schema.GroupVersionKind{
+		Group:   group,
+		Version: segments[1],
+		Kind:    segments[2],
+	}
+
+	return NewKindKey(gvk), segments[3:], nil
+}
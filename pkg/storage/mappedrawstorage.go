@@ -1,8 +1,9 @@
 package storage
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sync"
@@ -17,46 +18,74 @@ var (
 	ErrNotTracked = fmt.Errorf("untracked object: %w", ErrNotFound)
 )
 
+// FileFrame points to one frame (i.e. a YAML document, or a JSON object) of a file.
+type FileFrame struct {
+	// Path is the physical path of the file
+	Path string
+	// Frame is the index of the frame in the file, starting from 0
+	Frame int
+}
+
+func (f FileFrame) String() string {
+	return fmt.Sprintf("%s[%d]", f.Path, f.Frame)
+}
+
 // MappedRawStorage is an interface for RawStorages which store their
 // data in a flat/unordered directory format like manifest directories.
+// One file can hold multiple objects, each object in its own frame.
 type MappedRawStorage interface {
 	RawStorage
 
-	// AddMapping binds a Key's virtual path to a physical file path
-	AddMapping(key ObjectKey, path string)
+	// AddMapping binds a Key's virtual path to a frame of a physical file
+	AddMapping(key ObjectKey, file FileFrame)
 	// RemoveMapping removes the physical file
-	// path mapping matching the given Key
+	// frame mapping matching the given Key
 	RemoveMapping(key ObjectKey)
 
 	// SetMappings overwrites all known mappings
-	SetMappings(m map[ObjectKey]string)
+	SetMappings(m map[ObjectKey]FileFrame)
+	// GetKeys returns the keys mapped to the frames of the given physical file path
+	GetKeys(path string) []ObjectKey
 }
 
-func NewGenericMappedRawStorage(dir string) MappedRawStorage {
+func NewGenericMappedRawStorage(dir string, optFns ...RawStorageOptionsFunc) MappedRawStorage {
 	return &GenericMappedRawStorage{
 		dir:          dir,
-		fileMappings: make(map[ObjectKey]string),
+		fileMappings: make(map[ObjectKey]FileFrame),
 		mux:          &sync.Mutex{},
+		opts:         *newRawStorageOpts(optFns...),
 	}
 }
 
 // GenericMappedRawStorage is the default implementation of a MappedRawStorage,
 // it stores files in the given directory via a path translation map.
+// Files are written atomically, readers never see a partially written file.
+// When a file holds multiple objects, writing or deleting one of them only
+// rewrites its frame, the other frames are kept as-is, including comments.
+// The Checksum of an object in such a file changes when any frame is changed.
 type GenericMappedRawStorage struct {
 	dir          string
-	fileMappings map[ObjectKey]string
-	mux          *sync.Mutex
+	fileMappings map[ObjectKey]FileFrame
+	// mux guards fileMappings, and serializes the rewrites of multi-frame files
+	mux  *sync.Mutex
+	opts RawStorageOptions
 }
 
-func (r *GenericMappedRawStorage) realPath(key ObjectKey) (string, error) {
+func (r *GenericMappedRawStorage) realPath(key ObjectKey) (FileFrame, error) {
 	r.mux.Lock()
-	path, ok := r.fileMappings[key]
-	r.mux.Unlock()
+	defer r.mux.Unlock()
+
+	return r.realPathLocked(key)
+}
+
+// realPathLocked is realPath for callers already holding mux
+func (r *GenericMappedRawStorage) realPathLocked(key ObjectKey) (FileFrame, error) {
+	file, ok := r.fileMappings[key]
 	if !ok {
-		return "", fmt.Errorf("GenericMappedRawStorage: cannot resolve %q: %w", key, ErrNotTracked)
+		return FileFrame{}, fmt.Errorf("GenericMappedRawStorage: cannot resolve %q: %w", key, ErrNotTracked)
 	}
 
-	return path, nil
+	return file, nil
 }
 
 // If the file doesn't exist, returns ErrNotFound + ErrNotTracked.
@@ -66,7 +95,15 @@ func (r *GenericMappedRawStorage) Read(key ObjectKey) ([]byte, error) {
 		return nil, err
 	}
 
-	return ioutil.ReadFile(file)
+	frames, err := ReadFileFrames(file.Path)
+	if err != nil {
+		return nil, err
+	}
+	if file.Frame >= len(frames) {
+		return nil, fmt.Errorf("GenericMappedRawStorage: %s: frame not found: %w", file, ErrNotFound)
+	}
+
+	return frames[file.Frame], nil
 }
 
 func (r *GenericMappedRawStorage) Exists(key ObjectKey) bool {
@@ -75,41 +112,149 @@ func (r *GenericMappedRawStorage) Exists(key ObjectKey) bool {
 		return false
 	}
 
-	return util.FileExists(file)
+	return util.FileExists(file.Path)
 }
 
+// If the key isn't mapped to a file, the file is created in the location given by the PathStrategy
+// option, and the mapping is added. Without a PathStrategy, ErrNotFound + ErrNotTracked is returned.
 func (r *GenericMappedRawStorage) Write(key ObjectKey, content []byte) error {
-	// GenericMappedRawStorage isn't going to generate files itself,
-	// only write if the file is already known
-	file, err := r.realPath(key)
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	file, err := r.realPathLocked(key)
+	if errors.Is(err, ErrNotTracked) && r.opts.PathStrategy != nil {
+		return r.create(key, content)
+	} else if err != nil {
+		return err
+	}
+
+	return r.rewriteFrames(file.Path, func(frames serializer.FrameList) (serializer.FrameList, error) {
+		// (Re-)create empty or externally removed files
+		if len(frames) == 0 && file.Frame == 0 {
+			return serializer.FrameList{content}, nil
+		}
+		if file.Frame >= len(frames) {
+			return nil, fmt.Errorf("GenericMappedRawStorage: %s: frame not found: %w", file, ErrNotFound)
+		}
+
+		frames[file.Frame] = content
+		return frames, nil
+	})
+}
+
+// create writes a new file for the given key, in the location given by the PathStrategy.
+// The caller must hold mux.
+func (r *GenericMappedRawStorage) create(key ObjectKey, content []byte) error {
+	p, err := r.opts.PathStrategy.Path(key)
 	if err != nil {
 		return err
 	}
+	file := filepath.Join(r.dir, filepath.FromSlash(p))
+	if _, ok := ContentTypes[filepath.Ext(file)]; !ok {
+		return fmt.Errorf("GenericMappedRawStorage: unsupported file extension for %q", file)
+	}
+
+	// Don't overwrite files which aren't mapped to this key
+	if util.FileExists(file) {
+		return fmt.Errorf("GenericMappedRawStorage: cannot create %q for %q: %w", file, key, ErrAlreadyExists)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	if err := util.WriteFileAtomic(file, content, 0644, *r.opts.SyncDir); err != nil {
+		return err
+	}
 
-	return ioutil.WriteFile(file, content, 0644)
+	log.Debugf("GenericMappedRawStorage: AddMapping: %q -> %q", key, file)
+	r.fileMappings[key] = FileFrame{Path: file}
+	return nil
 }
 
-// If the file doesn't exist, returns ErrNotFound + ErrNotTracked.
-func (r *GenericMappedRawStorage) Delete(key ObjectKey) (err error) {
-	file, err := r.realPath(key)
+// If the file doesn't exist, returns ErrNotFound + ErrNotTracked. If the file holds other
+// objects too, only the frame of the given key is removed from it.
+func (r *GenericMappedRawStorage) Delete(key ObjectKey) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	file, err := r.realPathLocked(key)
 	if err != nil {
-		return
+		return err
 	}
 
 	// GenericMappedRawStorage files can be deleted
 	// externally, check that the file exists first
-	if util.FileExists(file) {
-		err = os.Remove(file)
+	if util.FileExists(file.Path) {
+		err = r.rewriteFrames(file.Path, func(frames serializer.FrameList) (serializer.FrameList, error) {
+			if file.Frame >= len(frames) {
+				return nil, fmt.Errorf("GenericMappedRawStorage: %s: frame not found: %w", file, ErrNotFound)
+			}
+
+			return append(frames[:file.Frame], frames[file.Frame+1:]...), nil
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	if err == nil {
-		r.RemoveMapping(key)
+	log.Debugf("GenericMappedRawStorage: RemoveMapping: %q", key)
+	delete(r.fileMappings, key)
+
+	// The frames after the deleted one moved one step up
+	for k, f := range r.fileMappings {
+		if f.Path == file.Path && f.Frame > file.Frame {
+			f.Frame--
+			r.fileMappings[k] = f
+		}
 	}
 
-	return
+	return nil
+}
+
+// rewriteFrames atomically rewrites the given file with the frames returned by fn, which receives the
+// current frames of the file (none if it doesn't exist). If the file ends up without any frames, it is
+// removed. The caller must hold mux.
+func (r *GenericMappedRawStorage) rewriteFrames(path string, fn func(frames serializer.FrameList) (serializer.FrameList, error)) (err error) {
+	var frames serializer.FrameList
+	if util.FileExists(path) {
+		if frames, err = ReadFileFrames(path); err != nil {
+			return err
+		}
+	}
+
+	frames, err = fn(frames)
+	if err != nil {
+		return err
+	}
+
+	switch len(frames) {
+	case 0:
+		return os.Remove(path)
+	case 1:
+		// Write a single frame as-is, without any separators
+		return util.WriteFileAtomic(path, frames[0], 0644, *r.opts.SyncDir)
+	}
+
+	// Separate the frames with the separator of the content type
+	var buf bytes.Buffer
+	fw := serializer.NewFrameWriter(ContentTypes[filepath.Ext(path)], &buf)
+	for _, frame := range frames {
+		// Make sure every frame ends with a newline, for the separator to start on its own line
+		if !bytes.HasSuffix(frame, []byte("\n")) {
+			frame = append(frame[:len(frame):len(frame)], '\n')
+		}
+		if _, err := fw.Write(frame); err != nil {
+			return err
+		}
+	}
+
+	return util.WriteFileAtomic(path, buf.Bytes(), 0644, *r.opts.SyncDir)
 }
 
 func (r *GenericMappedRawStorage) List(kind KindKey) ([]ObjectKey, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
 	result := make([]ObjectKey, 0)
 
 	for key := range r.fileMappings {
@@ -122,20 +267,21 @@ func (r *GenericMappedRawStorage) List(kind KindKey) ([]ObjectKey, error) {
 	return result, nil
 }
 
-// This returns the modification time as a UnixNano string.
+// This returns the checksum computed by the configured ChecksumStrategy,
+// by default the modification time as a UnixNano string.
 // If the file doesn't exist, returns ErrNotFound + ErrNotTracked.
 func (r *GenericMappedRawStorage) Checksum(key ObjectKey) (string, error) {
-	path, err := r.realPath(key)
+	file, err := r.realPath(key)
 	if err != nil {
 		return "", err
 	}
 
-	return checksumFromModTime(path)
+	return r.opts.Checksum.Checksum(file.Path)
 }
 
 func (r *GenericMappedRawStorage) ContentType(key ObjectKey) (ct serializer.ContentType) {
 	if file, err := r.realPath(key); err == nil {
-		ct = ContentTypes[filepath.Ext(file)] // Retrieve the correct format based on the extension
+		ct = ContentTypes[filepath.Ext(file.Path)] // Retrieve the correct format based on the extension
 	}
 
 	return
@@ -145,20 +291,43 @@ func (r *GenericMappedRawStorage) WatchDir() string {
 	return r.dir
 }
 
+// GetKey returns the key mapped to the given path. If the file holds multiple
+// objects, the key of the first frame is returned, use GetKeys to get all of them.
 func (r *GenericMappedRawStorage) GetKey(path string) (ObjectKey, error) {
-	for key, p := range r.fileMappings {
-		if p == path {
-			return key, nil
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	var result ObjectKey
+	for key, f := range r.fileMappings {
+		if f.Path == path && (result == nil || f.Frame < r.fileMappings[result].Frame) {
+			result = key
+		}
+	}
+	if result == nil {
+		return objectKey{}, fmt.Errorf("no mapping found for path %q", path)
+	}
+
+	return result, nil
+}
+
+func (r *GenericMappedRawStorage) GetKeys(path string) []ObjectKey {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	var result []ObjectKey
+	for key, f := range r.fileMappings {
+		if f.Path == path {
+			result = append(result, key)
 		}
 	}
 
-	return objectKey{}, fmt.Errorf("no mapping found for path %q", path)
+	return result
 }
 
-func (r *GenericMappedRawStorage) AddMapping(key ObjectKey, path string) {
-	log.Debugf("GenericMappedRawStorage: AddMapping: %q -> %q", key, path)
+func (r *GenericMappedRawStorage) AddMapping(key ObjectKey, file FileFrame) {
+	log.Debugf("GenericMappedRawStorage: AddMapping: %q -> %q", key, file)
 	r.mux.Lock()
-	r.fileMappings[key] = path
+	r.fileMappings[key] = file
 	r.mux.Unlock()
 }
 
@@ -169,9 +338,21 @@ func (r *GenericMappedRawStorage) RemoveMapping(key ObjectKey) {
 	r.mux.Unlock()
 }
 
-func (r *GenericMappedRawStorage) SetMappings(m map[ObjectKey]string) {
+func (r *GenericMappedRawStorage) SetMappings(m map[ObjectKey]FileFrame) {
 	log.Debugf("GenericMappedRawStorage: SetMappings: %v", m)
 	r.mux.Lock()
 	r.fileMappings = m
 	r.mux.Unlock()
 }
+
+// ReadFileFrames reads the frames (i.e. YAML documents, or JSON objects) of the given file. The
+// content type is decided by the extension of the file. A frame's index in the returned list is
+// the index used in FileFrame.
+func ReadFileFrames(path string) (serializer.FrameList, error) {
+	ct, ok := ContentTypes[filepath.Ext(path)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported file extension for %q", path)
+	}
+
+	return serializer.ReadFrameList(serializer.NewFrameReader(ct, serializer.FromFile(path)))
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+)
+
+const multiDocCars = `# The first car
+apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: a
+  namespace: default
+spec:
+  brand: Volvo
+---
+# The second car
+apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: b
+  namespace: default
+spec:
+  brand: Volvo
+---
+# The third car
+apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: c
+  namespace: default
+spec:
+  brand: Volvo
+`
+
+func TestGenericMappedRawStorage_MultiFrame(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cars.yaml")
+	if err := os.WriteFile(file, []byte(multiDocCars), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := NewGenericMappedRawStorage(filepath.Dir(file))
+	s := newTestStorage(raw)
+	keys := map[string]ObjectKey{}
+	for i, name := range []string{"a", "b", "c"} {
+		keys[name] = NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/"+name))
+		raw.AddMapping(keys[name], FileFrame{Path: file, Frame: i})
+	}
+
+	readFile := func() string {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	obj, err := s.Get(keys["b"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.GetName() != "b" {
+		t.Errorf("expected object b, got %q", obj.GetName())
+	}
+
+	// Only the frame of b is rewritten, the comments of the others are kept
+	obj.(*v1alpha1.Car).Spec.Brand = "Saab"
+	if err := s.Update(obj); err != nil {
+		t.Fatal(err)
+	}
+	content := readFile()
+	if !strings.Contains(content, "# The first car") || !strings.Contains(content, "# The third car") {
+		t.Errorf("expected the comments of the other frames to be kept, got:\n%s", content)
+	}
+	if strings.Count(content, "brand: Saab") != 1 || strings.Count(content, "brand: Volvo") != 2 {
+		t.Errorf("expected only b to be updated, got:\n%s", content)
+	}
+
+	// Deleting a frame moves the ones after it
+	if err := s.Delete(keys["a"]); err != nil {
+		t.Fatal(err)
+	}
+	for name, brand := range map[string]string{"b": "Saab", "c": "Volvo"} {
+		obj, err := s.Get(keys[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := obj.(*v1alpha1.Car).Spec.Brand; obj.GetName() != name || got != brand {
+			t.Errorf("expected %s with brand %s, got %s with brand %s", name, brand, obj.GetName(), got)
+		}
+	}
+	if content := readFile(); strings.Contains(content, "# The first car") || !strings.Contains(content, "# The third car") {
+		t.Errorf("expected only the first frame to be removed, got:\n%s", content)
+	}
+
+	// The file is removed together with its last frame
+	for _, name := range []string{"b", "c"} {
+		if err := s.Delete(keys[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("expected the file to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"sync"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+)
+
+// NewMemoryRawStorage creates a new MemoryRawStorage, which stores its
+// contents encoded in the given ContentType.
+func NewMemoryRawStorage(ct serializer.ContentType) RawStorage {
+	if extForContentType(ct) == "" {
+		panic("Invalid content type")
+	}
+	return &MemoryRawStorage{
+		ct:      ct,
+		objects: make(map[ObjectKey]*memoryObject),
+		mux:     &sync.RWMutex{},
+	}
+}
+
+// MemoryRawStorage is a RawStorage which stores objects in memory. It supports
+// any number of GroupVersions, and is safe for concurrent use. Its contents are
+// lost when the process exits, which makes it suitable for tests and dry runs.
+// As there is nothing on disk, WatchDir returns an empty string, and GetKey
+// resolves the virtual paths in the form <group>/<version>/<kind>/<identifier>.
+type MemoryRawStorage struct {
+	ct       serializer.ContentType
+	objects  map[ObjectKey]*memoryObject
+	revision uint64
+	mux      *sync.RWMutex
+}
+
+// memoryObject holds the content of one stored object, together with
+// the revision it was last written in.
+type memoryObject struct {
+	content  []byte
+	revision uint64
+}
+
+var _ RawStorage = &MemoryRawStorage{}
+
+// memoryKey normalizes the given key so that it can be used for map lookups,
+// regardless of the underlying implementation of ObjectKey.
+func memoryKey(key ObjectKey) ObjectKey {
+	return NewObjectKey(NewKindKey(key.GetGVK()), runtime.NewIdentifier(key.GetIdentifier()))
+}
+
+func memoryPath(key ObjectKey) string {
+	return path.Join(key.GetGroup(), key.GetVersion(), key.GetKind(), key.GetIdentifier())
+}
+
+func (r *MemoryRawStorage) Read(key ObjectKey) ([]byte, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	obj, ok := r.objects[memoryKey(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	// Return a copy, so the caller can't modify the stored content
+	return append([]byte(nil), obj.content...), nil
+}
+
+func (r *MemoryRawStorage) Exists(key ObjectKey) bool {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	_, ok := r.objects[memoryKey(key)]
+	return ok
+}
+
+func (r *MemoryRawStorage) Write(key ObjectKey, content []byte) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.revision++
+	r.objects[memoryKey(key)] = &memoryObject{
+		content:  append([]byte(nil), content...),
+		revision: r.revision,
+	}
+	return nil
+}
+
+func (r *MemoryRawStorage) Delete(key ObjectKey) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	k := memoryKey(key)
+	if _, ok := r.objects[k]; !ok {
+		return ErrNotFound
+	}
+
+	delete(r.objects, k)
+	return nil
+}
+
+func (r *MemoryRawStorage) List(kind KindKey) ([]ObjectKey, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	result := make([]ObjectKey, 0)
+	for key := range r.objects {
+		if key.EqualsGVK(kind, true) {
+			result = append(result, key)
+		}
+	}
+
+	return result, nil
+}
+
+// This returns the revision in which the object was last written.
+// If the object doesn't exist, return ErrNotFound
+func (r *MemoryRawStorage) Checksum(key ObjectKey) (string, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	obj, ok := r.objects[memoryKey(key)]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return strconv.FormatUint(obj.revision, 10), nil
+}
+
+func (r *MemoryRawStorage) ContentType(_ ObjectKey) serializer.ContentType {
+	return r.ct
+}
+
+func (r *MemoryRawStorage) WatchDir() string {
+	return ""
+}
+
+func (r *MemoryRawStorage) GetKey(p string) (ObjectKey, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	for key := range r.objects {
+		if memoryPath(key) == path.Clean(p) {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no object found for path %q", p)
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var carGVK = v1alpha1.SchemeGroupVersion.WithKind("Car")
+
+func newTestCar(name, brand string) *v1alpha1.Car {
+	car := &v1alpha1.Car{}
+	car.SetGroupVersionKind(carGVK)
+	car.Name = name
+	car.Namespace = "default"
+	car.Spec.Brand = brand
+	return car
+}
+
+func newTestStorage(raw RawStorage) Storage {
+	return NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+}
+
+func TestMemoryRawStorage(t *testing.T) {
+	raw := NewMemoryRawStorage(serializer.ContentTypeYAML)
+	key := NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/foo"))
+
+	if _, err := raw.Read(key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := raw.Write(key, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	sum1, err := raw.Checksum(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := raw.Write(key, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	sum2, err := raw.Checksum(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum1 == sum2 {
+		t.Errorf("expected the checksum to change after a write, got %q twice", sum1)
+	}
+	if content, err := raw.Read(key); err != nil || string(content) != "bar" {
+		t.Errorf("Read() = %q, %v, want %q", content, err, "bar")
+	}
+
+	// Another GroupVersion must be stored independently
+	otherGVK := schema.GroupVersionKind{Group: "other.example.com", Version: "v1", Kind: "Car"}
+	otherKey := NewObjectKey(NewKindKey(otherGVK), runtime.NewIdentifier("default/foo"))
+	if err := raw.Write(otherKey, []byte("baz")); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := raw.List(NewKindKey(carGVK)); err != nil || len(keys) != 1 {
+		t.Errorf("List() = %v, %v, want one key", keys, err)
+	}
+
+	got, err := raw.GetKey("other.example.com/v1/Car/default/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetGVK() != otherGVK || got.GetIdentifier() != "default/foo" {
+		t.Errorf("GetKey() = %v, want %v", got, otherKey)
+	}
+
+	if err := raw.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if raw.Exists(key) {
+		t.Error("expected the key to be deleted")
+	}
+	if err := raw.Delete(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMemoryRawStorage_GenericStorage(t *testing.T) {
+	s := newTestStorage(NewMemoryRawStorage(serializer.ContentTypeYAML))
+	kind := NewKindKey(carGVK)
+
+	for _, name := range []string{"foo", "bar"} {
+		if err := s.Create(newTestCar(name, "Acura")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Create(newTestCar("foo", "Acura")); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("expected ErrAlreadyExists, got %v", err)
+	}
+
+	objs, err := s.List(kind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 {
+		t.Errorf("expected 2 objects, got %d", len(objs))
+	}
+
+	key := NewObjectKey(kind, runtime.NewIdentifier("default/foo"))
+	if err := s.Update(newTestCar("foo", "Volvo")); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brand := obj.(*v1alpha1.Car).Spec.Brand; brand != "Volvo" {
+		t.Errorf("expected brand Volvo, got %q", brand)
+	}
+
+	if err := s.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
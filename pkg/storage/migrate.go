@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+	log "github.com/sirupsen/logrus"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// MigrationResult is the result of migrating one stored Object using MigrateObjects.
+type MigrationResult struct {
+	// Key is the key the Object was stored under before the migration
+	Key ObjectKey
+	// From is the GroupVersionKind the Object was stored as. It is empty if the content couldn't be read.
+	From schema.GroupVersionKind
+	// To is the preferred GroupVersionKind of the Object's kind
+	To schema.GroupVersionKind
+	// Migrated is true if the Object was written as To, and false if it already was stored as To
+	Migrated bool
+	// Err is set if the Object couldn't be migrated
+	Err error
+}
+
+// MigrateObjects converts the stored Objects of every kind registered in the scheme of the Storage to the
+// preferred version of their kind, using the serializer.Converter. Objects are first converted to their hub
+// (or internal) version, and then to the preferred version. The comments of YAML content are preserved.
+// Like RelocateObjects, the content is written to the RawStorage directly. MappedRawStorages keep the objects
+// in their current files, the other RawStorages store them under the key for the preferred version, deleting
+// the old one. Failing to migrate an Object doesn't stop the migration, the error is set in its result.
+// Errors listing the Objects are returned directly. The results are ordered by kind, and then by key.
+func MigrateObjects(s Storage) ([]MigrationResult, error) {
+	var results []MigrationResult
+	for _, gvks := range objectKinds(s.Serializer().Scheme()) {
+		keys, err := listAllVersions(s.RawStorage(), gvks)
+		if err != nil {
+			return results, err
+		}
+
+		// The kinds are ordered by version priority
+		for _, key := range keys {
+			results = append(results, migrateObject(s, key, gvks[0]))
+		}
+	}
+
+	return results, nil
+}
+
+// migrateObject converts the Object stored under key to the given GroupVersionKind, if needed.
+func migrateObject(s Storage, key ObjectKey, to schema.GroupVersionKind) MigrationResult {
+	result := MigrationResult{Key: key, To: to}
+	raw := s.RawStorage()
+
+	content, err := raw.Read(key)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	partObj, err := runtime.NewPartialObject(content)
+	if err != nil {
+		result.Err = fmt.Errorf("couldn't decode %s: %w", key, err)
+		return result
+	}
+	result.From = partObj.GetObjectKind().GroupVersionKind()
+
+	// The keys of MappedRawStorages follow the content, the other RawStorages use them to locate it
+	newKey := key
+	if _, ok := raw.(MappedRawStorage); !ok {
+		newKey = NewObjectKey(NewKindKey(to), key)
+	}
+	if result.From == to && newKey.GetVersion() == key.GetVersion() {
+		return result
+	}
+
+	ct := raw.ContentType(key)
+	if len(ct) == 0 {
+		ct = serializer.ContentTypeJSON
+	}
+
+	obj, err := convertContent(s.Serializer(), ct, content, to)
+	if err != nil {
+		result.Err = fmt.Errorf("couldn't convert %s to %s: %w", key, to, err)
+		return result
+	}
+
+	var newContent bytes.Buffer
+	if err := s.Serializer().Encoder(serializer.WithCommentsEncode(true)).Encode(serializer.NewFrameWriter(ct, &newContent), obj); err != nil {
+		result.Err = err
+		return result
+	}
+
+	log.Debugf("MigrateObjects: Migrating %s from %s to %s", key, result.From, to)
+	if newKey.GetVersion() == key.GetVersion() {
+		result.Err = raw.Write(key, newContent.Bytes())
+	} else {
+		result.Err = moveContent(raw, key, newKey, newContent.Bytes())
+	}
+	result.Migrated = result.Err == nil
+	return result
+}
+
+// convertContent decodes content into the hub version of its kind, and converts it to the given
+// GroupVersionKind. The comments of YAML content are kept, see serializer.SetCommentSource.
+func convertContent(ser serializer.Serializer, ct serializer.ContentType, content []byte, to schema.GroupVersionKind) (kruntime.Object, error) {
+	hub, err := ser.Decoder(serializer.WithConvertToHubDecode(true)).Decode(serializer.NewFrameReader(ct, serializer.FromBytes(content)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Hubs of CRD-style conversions may already be of the desired version
+	obj := hub
+	if hub.GetObjectKind().GroupVersionKind() != to {
+		if obj, err = ser.Converter().ConvertIntoNew(hub, to); err != nil {
+			return nil, err
+		}
+	}
+	obj.GetObjectKind().SetGroupVersionKind(to)
+
+	if ct == serializer.ContentTypeYAML {
+		source, err := yaml.Parse(string(content))
+		if err != nil {
+			return nil, err
+		}
+		if err := serializer.SetCommentSource(obj, source); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// moveContent writes content to newKey, and deletes key. If newKey is already taken, ErrAlreadyExists is returned.
+func moveContent(raw RawStorage, key, newKey ObjectKey, content []byte) error {
+	if raw.Exists(newKey) {
+		return fmt.Errorf("couldn't move %s to %s: %w", key, newKey, ErrAlreadyExists)
+	}
+	if err := raw.Write(newKey, content); err != nil {
+		return err
+	}
+	return raw.Delete(key)
+}
+
+// listAllVersions lists the keys of the given versions of a kind, without duplicates, sorted by key.
+// Versions which the RawStorage has no directory for, or can't store at all, have no Objects.
+func listAllVersions(raw RawStorage, gvks []schema.GroupVersionKind) ([]ObjectKey, error) {
+	var keys []ObjectKey
+	seen := make(map[string]bool)
+	for _, gvk := range gvks {
+		versionKeys, err := raw.List(NewKindKey(gvk))
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrUnsupportedGroupVersion) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, key := range versionKeys {
+			if !seen[key.String()] {
+				seen[key.String()] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys, nil
+}
+
+// objectKinds returns the external GroupVersionKinds of the Objects registered in the scheme, i.e.
+// of the types implementing runtime.Object, which excludes e.g. lists. The GroupVersionKinds are
+// grouped by kind, and ordered by version priority, so the preferred version of a kind comes first.
+func objectKinds(scheme *kruntime.Scheme) [][]schema.GroupVersionKind {
+	var kinds [][]schema.GroupVersionKind
+	index := make(map[schema.GroupKind]int)
+	for _, gv := range scheme.PrioritizedVersionsAllGroups() {
+		names := make([]string, 0)
+		for name := range scheme.KnownTypes(gv) {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			gvk := gv.WithKind(name)
+			obj, err := scheme.New(gvk)
+			if err != nil {
+				continue
+			}
+			if _, ok := obj.(runtime.Object); !ok {
+				continue
+			}
+
+			i, ok := index[gvk.GroupKind()]
+			if !ok {
+				i = len(kinds)
+				index[gvk.GroupKind()] = i
+				kinds = append(kinds, nil)
+			}
+			kinds[i] = append(kinds[i], gvk)
+		}
+	}
+	return kinds
+}
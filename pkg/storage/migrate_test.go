@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+var (
+	widgetV1GVK = schema.GroupVersionKind{Group: "test.libgitops", Version: "v1alpha1", Kind: "Widget"}
+	widgetV2GVK = schema.GroupVersionKind{Group: "test.libgitops", Version: "v1alpha2", Kind: "Widget"}
+)
+
+// widgetV1 is the old version of the Widget kind, with a single color
+type widgetV1 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Color             string `json:"color"`
+}
+
+func (w *widgetV1) DeepCopyObject() kruntime.Object {
+	out := *w
+	w.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	return &out
+}
+
+func (w *widgetV1) ConvertTo(hub conversion.Hub) error {
+	into := hub.(*widgetV2)
+	into.ObjectMeta = w.ObjectMeta
+	into.Colors = []string{w.Color}
+	return nil
+}
+
+func (w *widgetV1) ConvertFrom(hub conversion.Hub) error {
+	from := hub.(*widgetV2)
+	w.ObjectMeta = from.ObjectMeta
+	if len(from.Colors) != 0 {
+		w.Color = from.Colors[0]
+	}
+	return nil
+}
+
+// widgetV2 is the preferred version of the Widget kind, with a list of colors
+type widgetV2 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Colors            []string `json:"colors"`
+}
+
+func (w *widgetV2) DeepCopyObject() kruntime.Object {
+	out := *w
+	w.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Colors = append([]string(nil), w.Colors...)
+	return &out
+}
+
+func (*widgetV2) Hub() {}
+
+func newWidgetSerializer(t *testing.T) serializer.Serializer {
+	scheme := kruntime.NewScheme()
+	scheme.AddKnownTypeWithName(widgetV1GVK, &widgetV1{})
+	scheme.AddKnownTypeWithName(widgetV2GVK, &widgetV2{})
+	if err := scheme.SetVersionPriority(widgetV2GVK.GroupVersion(), widgetV1GVK.GroupVersion()); err != nil {
+		t.Fatal(err)
+	}
+	return serializer.NewSerializer(scheme, nil)
+}
+
+func TestMigrateObjects(t *testing.T) {
+	oldContent := `# An old widget
+apiVersion: test.libgitops/v1alpha1
+kind: Widget
+metadata:
+  name: old
+  namespace: default
+color: red # the favorite
+`
+	newContent := `apiVersion: test.libgitops/v1alpha2
+kind: Widget
+metadata:
+  name: new
+  namespace: default
+colors:
+- blue
+`
+	oldKey := NewObjectKey(NewKindKey(widgetV1GVK), runtime.NewIdentifier("default/old"))
+	newKey := NewObjectKey(NewKindKey(widgetV2GVK), runtime.NewIdentifier("default/new"))
+
+	raw := NewMemoryRawStorage(serializer.ContentTypeYAML)
+	if err := raw.Write(oldKey, []byte(oldContent)); err != nil {
+		t.Fatal(err)
+	}
+	if err := raw.Write(newKey, []byte(newContent)); err != nil {
+		t.Fatal(err)
+	}
+	s := NewGenericStorage(raw, newWidgetSerializer(t), []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+
+	results, err := MigrateObjects(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %v", results)
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Key, r.Err)
+		}
+		if r.To != widgetV2GVK {
+			t.Errorf("%s: expected to migrate to %s, got %s", r.Key, widgetV2GVK, r.To)
+		}
+		wantMigrated := r.Key.GetIdentifier() == "default/old"
+		if r.Migrated != wantMigrated {
+			t.Errorf("%s: expected migrated %t, got %t", r.Key, wantMigrated, r.Migrated)
+		}
+	}
+
+	// The old Object is moved to the key of the preferred version
+	if raw.Exists(oldKey) {
+		t.Errorf("expected %s to be removed", oldKey)
+	}
+	migratedKey := NewObjectKey(NewKindKey(widgetV2GVK), runtime.NewIdentifier("default/old"))
+	content, err := raw.Read(migratedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# An old widget\n", "apiVersion: test.libgitops/v1alpha2\n", "- red\n", "the favorite"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("expected %q in the migrated content:\n%s", want, content)
+		}
+	}
+	if strings.Contains(string(content), "annotations") {
+		t.Errorf("expected no annotations in the migrated content:\n%s", content)
+	}
+
+	obj, err := s.Get(migratedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if colors := obj.(*widgetV2).Colors; len(colors) != 1 || colors[0] != "red" {
+		t.Errorf("expected colors [red], got %v", colors)
+	}
+
+	// Migrating again is a no-op
+	results, err = MigrateObjects(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Migrated || r.Err != nil {
+			t.Errorf("expected %s to be up-to-date, got %+v", r.Key, r)
+		}
+	}
+}
+
+func TestMigrateObjects_GenericRawStorage(t *testing.T) {
+	// The kind layout only supports the preferred GroupVersion, the old
+	// content is stored under its key until it is migrated
+	raw := NewGenericRawStorage(t.TempDir(), widgetV2GVK.GroupVersion(), serializer.ContentTypeYAML)
+	s := NewGenericStorage(raw, newWidgetSerializer(t), []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+
+	// No Widget has been stored yet, so there's no directory to list
+	results, err := MigrateObjects(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("expected no results, got %v", results)
+	}
+
+	key := NewObjectKey(NewKindKey(widgetV2GVK), runtime.NewIdentifier("default/old"))
+	if err := raw.Write(key, []byte(`apiVersion: test.libgitops/v1alpha1
+kind: Widget
+metadata:
+  name: old
+  namespace: default
+color: red
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err = MigrateObjects(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Migrated || results[0].Err != nil || results[0].From != widgetV1GVK {
+		t.Fatalf("expected %s to be migrated from %s, got %+v", key, widgetV1GVK, results)
+	}
+
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if colors := obj.(*widgetV2).Colors; len(colors) != 1 || colors[0] != "red" {
+		t.Errorf("expected colors [red], got %v", colors)
+	}
+}
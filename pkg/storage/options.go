@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+	"github.com/save-abandoned-projects/libgitops/pkg/util"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+)
+
+// RawStorageOptions configures the disk-backed RawStorage implementations,
+// i.e. GenericRawStorage and GenericMappedRawStorage.
+type RawStorageOptions struct {
+	// Sync the parent directory to disk after a file has been written. This makes sure a newly
+	// created file is not lost if the machine crashes right after the write. The file contents
+	// themselves are always synced to disk before they replace the old file. (Default: false)
+	SyncDir *bool
+
+	// Checksum specifies how RawStorage.Checksum is computed for the files on disk. Use e.g.
+	// SHA256Checksum or GitBlobChecksum for checksums that are stable across restarts and
+	// git clones. (Default: ModTimeChecksum)
+	Checksum ChecksumStrategy
+
+	// PathStrategy decides where GenericMappedRawStorage writes objects which aren't mapped to a file
+	// yet, after which the mapping is added automatically. If unset, only objects which are already
+	// mapped can be written. GenericRawStorage ignores this option. (Default: nil)
+	PathStrategy PathStrategy
+}
+
+type RawStorageOptionsFunc func(*RawStorageOptions)
+
+func WithSyncDir(syncDir bool) RawStorageOptionsFunc {
+	return func(opts *RawStorageOptions) {
+		opts.SyncDir = &syncDir
+	}
+}
+
+func WithChecksum(checksum ChecksumStrategy) RawStorageOptionsFunc {
+	return func(opts *RawStorageOptions) {
+		opts.Checksum = checksum
+	}
+}
+
+func WithPathStrategy(pathStrategy PathStrategy) RawStorageOptionsFunc {
+	return func(opts *RawStorageOptions) {
+		opts.PathStrategy = pathStrategy
+	}
+}
+
+func defaultRawStorageOpts() *RawStorageOptions {
+	return &RawStorageOptions{
+		SyncDir:  util.BoolPtr(false),
+		Checksum: ModTimeChecksum,
+	}
+}
+
+func newRawStorageOpts(fns ...RawStorageOptionsFunc) *RawStorageOptions {
+	opts := defaultRawStorageOpts()
+	for _, fn := range fns {
+		fn(opts)
+	}
+	return opts
+}
+
+// GenericStorageOptions configures GenericStorage.
+type GenericStorageOptions struct {
+	// DecodeWorkers is the amount of Objects read and decoded concurrently by List, ListMeta and Walk.
+	// The Objects are still returned in order, and filtered sequentially. A value of 1 or less reads
+	// and decodes the Objects one-by-one. (Default: 1)
+	DecodeWorkers *int
+
+	// SchemaValidator validates the encoded Objects against the OpenAPI schemas of their types before
+	// they are written by Create, Update and Patch. Invalid Objects are rejected with an InvalidError.
+	// See serializer.NewSchemaValidator. (Default: nil)
+	SchemaValidator serializer.SchemaValidator
+
+	// StatusSubresource makes Update and Patch ignore changes to the status of the Objects, like the status
+	// subresource of Kubernetes. The status can then only be changed using UpdateStatus. (Default: false)
+	StatusSubresource *bool
+
+	// TypeConverter converts the Objects to the typed values used by Apply to merge them and track the
+	// ownership of their fields. The default converter deduces the types from the Objects, treating lists
+	// as atomic. See managedfields.NewTypeConverter for using OpenAPI schemas. (Default: deduced)
+	TypeConverter managedfields.TypeConverter
+
+	// Unstructured makes the storage handle Objects of kinds which aren't registered in the scheme, e.g.
+	// plain Kubernetes manifests, as *runtime.Unstructured. Otherwise, reading them fails. Strategic merge
+	// patches aren't supported for these Objects, as they require the Go types. (Default: false)
+	Unstructured *bool
+
+	// AdmissionHooks are called for all writes, see WithAdmission. If any are given, NewGenericStorage returns
+	// the GenericStorage wrapped by the admission chain. (Default: nil)
+	AdmissionHooks []AdmissionHook
+}
+
+type GenericStorageOptionsFunc func(*GenericStorageOptions)
+
+func WithDecodeWorkers(workers int) GenericStorageOptionsFunc {
+	return func(opts *GenericStorageOptions) {
+		opts.DecodeWorkers = &workers
+	}
+}
+
+func WithSchemaValidation(validator serializer.SchemaValidator) GenericStorageOptionsFunc {
+	return func(opts *GenericStorageOptions) {
+		opts.SchemaValidator = validator
+	}
+}
+
+func WithStatusSubresource(enabled bool) GenericStorageOptionsFunc {
+	return func(opts *GenericStorageOptions) {
+		opts.StatusSubresource = &enabled
+	}
+}
+
+func WithTypeConverter(converter managedfields.TypeConverter) GenericStorageOptionsFunc {
+	return func(opts *GenericStorageOptions) {
+		opts.TypeConverter = converter
+	}
+}
+
+func WithUnstructured(enabled bool) GenericStorageOptionsFunc {
+	return func(opts *GenericStorageOptions) {
+		opts.Unstructured = &enabled
+	}
+}
+
+func WithAdmissionHooks(hooks ...AdmissionHook) GenericStorageOptionsFunc {
+	return func(opts *GenericStorageOptions) {
+		opts.AdmissionHooks = append(opts.AdmissionHooks, hooks...)
+	}
+}
+
+func defaultGenericStorageOpts() *GenericStorageOptions {
+	return &GenericStorageOptions{
+		DecodeWorkers:     util.IntPtr(1),
+		StatusSubresource: util.BoolPtr(false),
+		TypeConverter:     managedfields.NewDeducedTypeConverter(),
+		Unstructured:      util.BoolPtr(false),
+	}
+}
+
+// MakeGenericStorageOptions returns the default GenericStorageOptions, modified by the given funcs.
+func MakeGenericStorageOptions(fns ...GenericStorageOptionsFunc) *GenericStorageOptions {
+	return newGenericStorageOpts(fns...)
+}
+
+func newGenericStorageOpts(fns ...GenericStorageOptionsFunc) *GenericStorageOptions {
+	opts := defaultGenericStorageOpts()
+	for _, fn := range fns {
+		fn(opts)
+	}
+	return opts
+}
+
+// ImportOptions configures Import and ImportTar.
+type ImportOptions struct {
+	// Overwrite replaces the Objects which already exist in the Storage using Update. Otherwise, importing an
+	// existing Object fails with ErrAlreadyExists, unless SkipExisting is set. (Default: false)
+	Overwrite *bool
+
+	// SkipExisting leaves the Objects which already exist in the Storage as-is. It can't be combined with
+	// Overwrite. (Default: false)
+	SkipExisting *bool
+
+	// DryRun only reports what would be imported, without writing anything. (Default: false)
+	DryRun *bool
+}
+
+type ImportOptionsFunc func(*ImportOptions)
+
+func WithOverwrite(overwrite bool) ImportOptionsFunc {
+	return func(opts *ImportOptions) {
+		opts.Overwrite = &overwrite
+	}
+}
+
+func WithSkipExisting(skip bool) ImportOptionsFunc {
+	return func(opts *ImportOptions) {
+		opts.SkipExisting = &skip
+	}
+}
+
+func WithDryRun(dryRun bool) ImportOptionsFunc {
+	return func(opts *ImportOptions) {
+		opts.DryRun = &dryRun
+	}
+}
+
+func defaultImportOpts() *ImportOptions {
+	return &ImportOptions{
+		Overwrite:    util.BoolPtr(false),
+		SkipExisting: util.BoolPtr(false),
+		DryRun:       util.BoolPtr(false),
+	}
+}
+
+func newImportOpts(fns ...ImportOptionsFunc) *ImportOptions {
+	opts := defaultImportOpts()
+	for _, fn := range fns {
+		fn(opts)
+	}
+	return opts
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/filter"
+)
+
+// ContinueToken returns the token to pass to filter.Continue, in order to continue
+// listing right after the Object with the given key.
+func ContinueToken(key ObjectKey) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key.GetIdentifier()))
+}
+
+// parseContinueToken returns the identifier the given continue token was created for.
+func parseContinueToken(token string) (string, error) {
+	id, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("invalid continue token %q: %v: %w", token, err, filter.ErrInvalidFilterParams)
+	}
+
+	return string(id), nil
+}
+
+// sortKeys sorts the given keys by their identifiers, then by their GroupVersionKinds.
+func sortKeys(keys []ObjectKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].GetIdentifier() != keys[j].GetIdentifier() {
+			return keys[i].GetIdentifier() < keys[j].GetIdentifier()
+		}
+		return keys[i].String() < keys[j].String()
+	})
+}
+
+// ListKeys returns the keys of the given kind in the RawStorage, sorted the same way as ReadStorage.List
+// sorts the Objects. If continueToken is set, only the keys after the one it was created for are returned.
+func ListKeys(raw RawStorage, kind KindKey, continueToken string) ([]ObjectKey, error) {
+	keys, err := raw.List(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := parseContinueToken(continueToken)
+	if err != nil {
+		return nil, err
+	}
+
+	sortKeys(keys)
+	if len(continueToken) == 0 {
+		return keys, nil
+	}
+
+	// Skip the keys up to and including the one for the continue token
+	result := make([]ObjectKey, 0, len(keys))
+	for _, key := range keys {
+		if key.GetIdentifier() > after {
+			result = append(result, key)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"text/template"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+)
+
+// PathStrategy decides where GenericMappedRawStorage writes new objects, i.e. objects
+// for which no mapping to a file exists yet.
+type PathStrategy interface {
+	// Path returns the path of the file for the given key, relative to the storage directory.
+	// The extension of the path determines the content type of the file.
+	Path(key ObjectKey) (string, error)
+}
+
+// PathTemplateData is the data the template of a PathStrategy created by NewTemplatePathStrategy
+// is executed with. Namespace and Name are split from the identifier of the key using
+// runtime.SplitIdentifier, so Namespace is empty unless the identifier is in the "namespace/name" form.
+type PathTemplateData struct {
+	Group      string
+	Version    string
+	Kind       string
+	Identifier string
+	Namespace  string
+	Name       string
+}
+
+// NewTemplatePathStrategy creates a PathStrategy from the given text/template, which is executed
+// with PathTemplateData. Empty path segments are removed from the result, so e.g. a template of
+// "{{ .Kind }}/{{ .Namespace }}/{{ .Name }}.yaml" stores cluster-scoped objects in "<kind>/<name>.yaml".
+func NewTemplatePathStrategy(text string) (PathStrategy, error) {
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template %q: %w", text, err)
+	}
+
+	return &templatePathStrategy{tmpl}, nil
+}
+
+// templatePathStrategy implements PathStrategy.
+type templatePathStrategy struct {
+	tmpl *template.Template
+}
+
+func (s *templatePathStrategy) Path(key ObjectKey) (string, error) {
+	data := PathTemplateData{
+		Group:      key.GetGroup(),
+		Version:    key.GetVersion(),
+		Kind:       key.GetKind(),
+		Identifier: key.GetIdentifier(),
+	}
+	data.Namespace, data.Name = runtime.SplitIdentifier(key.GetIdentifier())
+
+	var buf bytes.Buffer
+	if err := s.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("couldn't compute the path for %s: %w", key, err)
+	}
+
+	// Make sure the path stays within the storage directory
+	p := path.Clean("/" + buf.String())[1:]
+	if len(p) == 0 {
+		return "", fmt.Errorf("empty path computed for %s", key)
+	}
+	return p, nil
+}
+
+// KindNamespaceNamePathStrategy is a PathStrategy storing new objects in YAML files
+// in the form <kind>/<namespace>/<name>.yaml.
+var KindNamespaceNamePathStrategy = mustTemplatePathStrategy("{{ .Kind }}/{{ .Namespace }}/{{ .Name }}.yaml")
+
+func mustTemplatePathStrategy(text string) PathStrategy {
+	s, err := NewTemplatePathStrategy(text)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
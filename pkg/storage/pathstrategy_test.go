@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestTemplatePathStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		gvk      schema.GroupVersionKind
+		id       string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "namespaced",
+			template: "{{ .Kind }}/{{ .Namespace }}/{{ .Name }}.yaml",
+			gvk:      carGVK,
+			id:       "default/foo",
+			want:     "Car/default/foo.yaml",
+		},
+		{
+			name:     "cluster-scoped",
+			template: "{{ .Kind }}/{{ .Namespace }}/{{ .Name }}.yaml",
+			gvk:      carGVK,
+			id:       "foo",
+			want:     "Car/foo.yaml",
+		},
+		{
+			name:     "group and version",
+			template: "{{ .Group }}/{{ .Version }}/{{ .Identifier }}.json",
+			gvk:      carGVK,
+			id:       "default/foo",
+			want:     carGVK.Group + "/" + carGVK.Version + "/default/foo.json",
+		},
+		{
+			name:     "stays within the directory",
+			template: "{{ .Name }}.yaml",
+			gvk:      carGVK,
+			id:       "default/../../foo",
+			want:     "foo.yaml",
+		},
+		{
+			name:     "unknown field",
+			template: "{{ .Foo }}.yaml",
+			gvk:      carGVK,
+			id:       "default/foo",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewTemplatePathStrategy(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := s.Path(NewObjectKey(NewKindKey(tt.gvk), runtime.NewIdentifier(tt.id)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Path() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Path() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenericMappedRawStorage_PathStrategy(t *testing.T) {
+	dir := t.TempDir()
+	key := NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/foo"))
+
+	// Without a PathStrategy, only mapped objects can be written
+	if err := newTestStorage(NewGenericMappedRawStorage(dir)).Create(newTestCar("foo", "Volvo")); !errors.Is(err, ErrNotTracked) {
+		t.Errorf("expected ErrNotTracked, got %v", err)
+	}
+
+	raw := NewGenericMappedRawStorage(dir, WithPathStrategy(KindNamespaceNamePathStrategy))
+	s := newTestStorage(raw)
+	if err := s.Create(newTestCar("foo", "Volvo")); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "Car", "default", "foo.yaml")
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("expected the file to be created: %v", err)
+	}
+	if got, err := raw.GetKey(file); err != nil || got != key {
+		t.Errorf("GetKey() = %v, %v, want %v", got, err, key)
+	}
+	if _, err := s.Get(key); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unmapped files are never overwritten
+	raw.RemoveMapping(key)
+	if err := s.Create(newTestCar("foo", "Volvo")); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("expected ErrAlreadyExists, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+// Preconditions must be fulfilled by the stored object before a write is carried out.
+type Preconditions struct {
+	// ResourceVersion must match the current resourceVersion of the stored
+	// object, as returned by RawStorage.Checksum. If empty, it is not checked.
+	// +optional
+	ResourceVersion string
+}
+
+// Check verifies that the preconditions hold for the object stored under the given key,
+// whose current resourceVersion is currentRV. If they don't, ErrConflict is returned.
+func (p Preconditions) Check(key ObjectKey, currentRV string) error {
+	if len(p.ResourceVersion) == 0 || p.ResourceVersion == currentRV {
+		return nil
+	}
+
+	return fmt.Errorf("%s: resourceVersion %q does not match the stored %q: %w", key, p.ResourceVersion, currentRV, ErrConflict)
+}
+
+// ApplyToDeleteOptions implements DeleteOption.
+func (p Preconditions) ApplyToDeleteOptions(target *DeleteOptions) {
+	target.Preconditions = p
+}
+
+// DeleteOptions is a generic struct for deletion options.
+type DeleteOptions struct {
+	// Preconditions must be fulfilled before the object is deleted.
+	Preconditions Preconditions
+}
+
+// DeleteOption is an interface which can be passed into Delete() as a variadic-length argument list.
+type DeleteOption interface {
+	// ApplyToDeleteOptions applies the configuration of the current object into a target DeleteOptions struct.
+	ApplyToDeleteOptions(target *DeleteOptions)
+}
+
+// MakeDeleteOptions makes a completed DeleteOptions struct from a list of DeleteOption implementations.
+func MakeDeleteOptions(opts ...DeleteOption) *DeleteOptions {
+	o := &DeleteOptions{}
+	for _, opt := range opts {
+		opt.ApplyToDeleteOptions(o)
+	}
+	return o
+}
+
+// resourceVersionFromPatch extracts metadata.resourceVersion from the given patch, and returns
+// it together with the patch stripped from it, as the resourceVersion is never stored on disk.
+// If the patch doesn't set the resourceVersion, it is returned as-is. For JSON patches, the
+// resourceVersion is extracted from a "test" operation, which would otherwise always fail.
+func resourceVersionFromPatch(patchType types.PatchType, patch []byte) (string, []byte, error) {
+	if patchType == types.JSONPatchType {
+		return resourceVersionFromJSONPatch(patch)
+	}
+
+	var p map[string]interface{}
+	// The yaml package supports both YAML and JSON
+	if err := yaml.Unmarshal(patch, &p); err != nil {
+		return "", nil, err
+	}
+
+	metadata, ok := p["metadata"].(map[string]interface{})
+	if !ok {
+		return "", patch, nil
+	}
+	rv, ok := metadata["resourceVersion"].(string)
+	if !ok {
+		return "", patch, nil
+	}
+
+	delete(metadata, "resourceVersion")
+	stripped, err := json.Marshal(p)
+	if err != nil {
+		return "", nil, err
+	}
+	return rv, stripped, nil
+}
+
+// resourceVersionFromJSONPatch extracts the resourceVersion tested for by the given JSON
+// patch, and returns it together with the patch stripped from the "test" operation.
+func resourceVersionFromJSONPatch(patch []byte) (string, []byte, error) {
+	var ops []map[string]interface{}
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return "", nil, err
+	}
+
+	for i, op := range ops {
+		if op["op"] != "test" || op["path"] != "/metadata/resourceVersion" {
+			continue
+		}
+		rv, ok := op["value"].(string)
+		if !ok {
+			return "", nil, fmt.Errorf("expected a string resourceVersion, got %v", op["value"])
+		}
+
+		stripped, err := json.Marshal(append(ops[:i:i], ops[i+1:]...))
+		if err != nil {
+			return "", nil, err
+		}
+		return rv, stripped, nil
+	}
+
+	return "", patch, nil
+}
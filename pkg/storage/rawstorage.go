@@ -6,7 +6,6 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"strconv"
 	"strings"
 
 	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
@@ -45,134 +44,184 @@ type RawStorage interface {
 	GetKey(path string) (ObjectKey, error)
 }
 
-func NewGenericRawStorage(dir string, gv schema.GroupVersion, ct serializer.ContentType) RawStorage {
+// NewGenericRawStorage creates a new GenericRawStorage using the kind-only layout
+// (see NewKindLayout), storing objects of the given GroupVersion only.
+func NewGenericRawStorage(dir string, gv schema.GroupVersion, ct serializer.ContentType, optFns ...RawStorageOptionsFunc) RawStorage {
+	return NewGenericRawStorageWithLayout(dir, NewKindLayout(gv), ct, optFns...)
+}
+
+// NewGenericRawStorageWithLayout creates a new GenericRawStorage using the given layout. Pass
+// GroupVersionKindLayout to store any kind, regardless of its GroupVersion, in the same directory.
+func NewGenericRawStorageWithLayout(dir string, layout RawStorageLayout, ct serializer.ContentType, optFns ...RawStorageOptionsFunc) RawStorage {
 	ext := extForContentType(ct)
 	if ext == "" {
 		panic("Invalid content type")
 	}
 	return &GenericRawStorage{
-		dir: dir,
-		gv:  gv,
-		ct:  ct,
-		ext: ext,
+		dir:    dir,
+		layout: layout,
+		ct:     ct,
+		ext:    ext,
+		opts:   *newRawStorageOpts(optFns...),
 	}
 }
 
 // GenericRawStorage is a rawstorage which stores objects as JSON files on disk,
-// in the form: <dir>/<kind>/<identifier>/metadata.json.
-// The GenericRawStorage only supports one GroupVersion at a time, and will error if given
-// any other resources
+// in the form: <dir>/<kind path>/<identifier>/metadata.json. The kind path is
+// determined by the RawStorageLayout, which also decides what GroupVersions
+// are supported. Every segment of the identifier is a directory, so namespaced
+// objects identified by "<namespace>/<name>" are stored in the form
+// <dir>/<kind path>/<namespace>/<name>/metadata.json, and cluster-scoped ones
+// in the form <dir>/<kind path>/<name>/metadata.json. Use RelocateObjects to
+// move objects stored using other identifiers into this layout. With the
+// default layout only one GroupVersion is supported at a time, and the
+// GenericRawStorage will error if given any other resources.
+// Files are written atomically, readers never see a partially written file.
 type GenericRawStorage struct {
-	dir string
-	gv  schema.GroupVersion
-	ct  serializer.ContentType
-	ext string
+	dir    string
+	layout RawStorageLayout
+	ct     serializer.ContentType
+	ext    string
+	opts   RawStorageOptions
 }
 
-func (r *GenericRawStorage) keyPath(key ObjectKey) string {
-	return path.Join(r.dir, key.GetKind(), key.GetIdentifier(), fmt.Sprintf("metadata%s", r.ext))
-}
+func (r *GenericRawStorage) keyPath(key ObjectKey) (string, error) {
+	kindPath, err := r.kindKeyPath(key)
+	if err != nil {
+		return "", err
+	}
 
-func (r *GenericRawStorage) kindKeyPath(kindKey KindKey) string {
-	return path.Join(r.dir, kindKey.GetKind())
+	return path.Join(kindPath, key.GetIdentifier(), fmt.Sprintf("metadata%s", r.ext)), nil
 }
 
-func (r *GenericRawStorage) validateGroupVersion(kind KindKey) error {
-	if r.gv.Group == kind.GetGroup() && r.gv.Version == kind.GetVersion() {
-		return nil
+func (r *GenericRawStorage) kindKeyPath(kindKey KindKey) (string, error) {
+	kindPath, err := r.layout.KindPath(kindKey)
+	if err != nil {
+		return "", err
 	}
 
-	return fmt.Errorf("GroupVersion %s/%s not supported by this GenericRawStorage", kind.GetGroup(), kind.GetVersion())
+	return path.Join(r.dir, kindPath), nil
 }
 
 func (r *GenericRawStorage) Read(key ObjectKey) ([]byte, error) {
-	// Validate GroupVersion first
-	if err := r.validateGroupVersion(key); err != nil {
+	file, err := r.keyPath(key)
+	if err != nil {
 		return nil, err
 	}
 
 	// Check if the resource indicated by key exists
-	if !r.Exists(key) {
+	if !util.FileExists(file) {
 		return nil, ErrNotFound
 	}
 
-	return ioutil.ReadFile(r.keyPath(key))
+	return ioutil.ReadFile(file)
 }
 
 func (r *GenericRawStorage) Exists(key ObjectKey) bool {
-	// Validate GroupVersion first
-	if err := r.validateGroupVersion(key); err != nil {
+	file, err := r.keyPath(key)
+	if err != nil {
 		return false
 	}
 
-	return util.FileExists(r.keyPath(key))
+	return util.FileExists(file)
 }
 
 func (r *GenericRawStorage) Write(key ObjectKey, content []byte) error {
-	// Validate GroupVersion first
-	if err := r.validateGroupVersion(key); err != nil {
+	file, err := r.keyPath(key)
+	if err != nil {
 		return err
 	}
 
-	file := r.keyPath(key)
-
 	// Create the underlying directories if they do not exist already
-	if !r.Exists(key) {
+	if !util.FileExists(file) {
 		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
 			return err
 		}
 	}
 
-	return ioutil.WriteFile(file, content, 0644)
+	return util.WriteFileAtomic(file, content, 0644, *r.opts.SyncDir)
 }
 
 func (r *GenericRawStorage) Delete(key ObjectKey) error {
-	// Validate GroupVersion first
-	if err := r.validateGroupVersion(key); err != nil {
+	file, err := r.keyPath(key)
+	if err != nil {
 		return err
 	}
 
 	// Check if the resource indicated by key exists
-	if !r.Exists(key) {
+	if !util.FileExists(file) {
 		return ErrNotFound
 	}
 
-	return os.RemoveAll(path.Dir(r.keyPath(key)))
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+
+	// Remove the directories of the identifier which are left empty (e.g. the
+	// namespace directory after its last object), but not the kind directory.
+	// Directories still holding other objects (or other files) are kept.
+	kindPath, err := r.kindKeyPath(key)
+	if err != nil {
+		return err
+	}
+	for dir := path.Dir(file); dir != kindPath && strings.HasPrefix(dir, kindPath); dir = path.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+
+	return nil
 }
 
 func (r *GenericRawStorage) List(kind KindKey) ([]ObjectKey, error) {
-	// Validate GroupVersion first
-	if err := r.validateGroupVersion(kind); err != nil {
+	kindPath, err := r.kindKeyPath(kind)
+	if err != nil {
 		return nil, err
 	}
 
-	entries, err := ioutil.ReadDir(r.kindKeyPath(kind))
+	// The identifier may span multiple directories (e.g. "namespace/name"),
+	// every directory holding a metadata file is an object
+	result := make([]ObjectKey, 0)
+	metadataFile := fmt.Sprintf("metadata%s", r.ext)
+	err = filepath.Walk(kindPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != metadataFile {
+			return nil
+		}
+
+		dir, err := filepath.Rel(kindPath, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		if dir != "." {
+			result = append(result, NewObjectKey(kind, runtime.NewIdentifier(filepath.ToSlash(dir))))
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	result := make([]ObjectKey, 0, len(entries))
-	for _, entry := range entries {
-		result = append(result, NewObjectKey(kind, runtime.NewIdentifier(entry.Name())))
-	}
-
 	return result, nil
 }
 
-// This returns the modification time as a UnixNano string
+// This returns the checksum computed by the configured ChecksumStrategy,
+// by default the modification time as a UnixNano string.
 // If the file doesn't exist, return ErrNotFound
 func (r *GenericRawStorage) Checksum(key ObjectKey) (string, error) {
-	// Validate GroupVersion first
-	if err := r.validateGroupVersion(key); err != nil {
+	file, err := r.keyPath(key)
+	if err != nil {
 		return "", err
 	}
 
 	// Check if the resource indicated by key exists
-	if !r.Exists(key) {
+	if !util.FileExists(file) {
 		return "", ErrNotFound
 	}
 
-	return checksumFromModTime(r.keyPath(key))
+	return r.opts.Checksum.Checksum(file)
 }
 
 func (r *GenericRawStorage) ContentType(_ ObjectKey) serializer.ContentType {
@@ -187,7 +236,7 @@ func (r *GenericRawStorage) GetKey(p string) (ObjectKey, error) {
 	splitDir := strings.Split(filepath.Clean(r.dir), string(os.PathSeparator))
 	splitPath := strings.Split(filepath.Clean(p), string(os.PathSeparator))
 
-	if len(splitPath) < len(splitDir)+2 {
+	if len(splitPath) <= len(splitDir) {
 		return nil, fmt.Errorf("path not long enough: %s", p)
 	}
 
@@ -196,22 +245,19 @@ func (r *GenericRawStorage) GetKey(p string) (ObjectKey, error) {
 			return nil, fmt.Errorf("path has wrong base: %s", p)
 		}
 	}
-	kind := splitPath[len(splitDir)]
-	uid := splitPath[len(splitDir)+1]
-	gvk := schema.GroupVersionKind{
-		Group:   r.gv.Group,
-		Version: r.gv.Version,
-		Kind:    kind,
-	}
-
-	return NewObjectKey(NewKindKey(gvk), runtime.NewIdentifier(uid)), nil
-}
 
-func checksumFromModTime(path string) (string, error) {
-	fi, err := os.Stat(path)
+	// Let the layout parse the kind, the identifier follows directly after it,
+	// spanning all directories up to the metadata file (e.g. "namespace/name")
+	kind, rest, err := r.layout.KindForPath(splitPath[len(splitDir):])
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if len(rest) > 0 && rest[len(rest)-1] == fmt.Sprintf("metadata%s", r.ext) {
+		rest = rest[:len(rest)-1]
+	}
+	if len(rest) < 1 {
+		return nil, fmt.Errorf("path not long enough: %s", p)
 	}
 
-	return strconv.FormatInt(fi.ModTime().UnixNano(), 10), nil
+	return NewObjectKey(kind, runtime.NewIdentifier(strings.Join(rest, "/"))), nil
 }
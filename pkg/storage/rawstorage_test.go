@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestGenericRawStorage_GroupVersionKindLayout(t *testing.T) {
+	dir := t.TempDir()
+	raw := NewGenericRawStorageWithLayout(dir, GroupVersionKindLayout, serializer.ContentTypeYAML)
+
+	tests := []struct {
+		gvk      schema.GroupVersionKind
+		wantPath string
+	}{
+		{
+			gvk:      carGVK,
+			wantPath: filepath.Join(dir, carGVK.Group, carGVK.Version, "Car", "foo", "metadata.yaml"),
+		},
+		{
+			gvk:      schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+			wantPath: filepath.Join(dir, "core", "v1", "ConfigMap", "foo", "metadata.yaml"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.gvk.String(), func(t *testing.T) {
+			key := NewObjectKey(NewKindKey(tt.gvk), runtime.NewIdentifier("foo"))
+			if err := raw.Write(key, []byte("foo")); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := raw.GetKey(tt.wantPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.GetGVK() != tt.gvk || got.GetIdentifier() != "foo" {
+				t.Errorf("GetKey() = %v, want %v", got, key)
+			}
+
+			keys, err := raw.List(NewKindKey(tt.gvk))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 1 || keys[0].GetIdentifier() != "foo" {
+				t.Errorf("List() = %v, want [%v]", keys, key)
+			}
+		})
+	}
+}
+
+func TestGenericRawStorage_KindLayout(t *testing.T) {
+	raw := NewGenericRawStorage(t.TempDir(), carGVK.GroupVersion(), serializer.ContentTypeYAML)
+
+	other := schema.GroupVersionKind{Group: "other.example.com", Version: "v1", Kind: "Car"}
+	if err := raw.Write(NewObjectKey(NewKindKey(other), runtime.NewIdentifier("foo")), []byte("foo")); err == nil {
+		t.Error("expected an error when writing an unsupported GroupVersion")
+	}
+}
+
+func TestGenericRawStorage_Scopes(t *testing.T) {
+	dir := t.TempDir()
+	raw := NewGenericRawStorage(dir, carGVK.GroupVersion(), serializer.ContentTypeYAML)
+
+	// Cars are namespaced, Motorcycles cluster-scoped
+	motorcycleGVK := v1alpha1.SchemeGroupVersion.WithKind("Motorcycle")
+	mapper := runtime.NewRESTMapper(scheme.Scheme, motorcycleGVK.GroupKind())
+	s := NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.NewScopedNameIdentifier(mapper, scheme.Scheme)})
+
+	motorcycle := &v1alpha1.Motorcycle{}
+	motorcycle.Name = "bar"
+	motorcycle.Namespace = "ignored"
+
+	tests := []struct {
+		obj      runtime.Object
+		gvk      schema.GroupVersionKind
+		wantID   string
+		wantPath string
+	}{
+		{
+			obj:      newTestCar("foo", "Volvo"),
+			gvk:      carGVK,
+			wantID:   "default/foo",
+			wantPath: filepath.Join(dir, "Car", "default", "foo", "metadata.yaml"),
+		},
+		{
+			obj:      motorcycle,
+			gvk:      motorcycleGVK,
+			wantID:   "bar",
+			wantPath: filepath.Join(dir, "Motorcycle", "bar", "metadata.yaml"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.gvk.Kind, func(t *testing.T) {
+			if err := s.Create(tt.obj); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := raw.GetKey(tt.wantPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.GetGVK() != tt.gvk || got.GetIdentifier() != tt.wantID {
+				t.Errorf("GetKey() = %v, want identifier %q", got, tt.wantID)
+			}
+
+			keys, err := raw.List(NewKindKey(tt.gvk))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 1 || keys[0].GetIdentifier() != tt.wantID {
+				t.Fatalf("List() = %v, want identifier %q", keys, tt.wantID)
+			}
+
+			obj, err := s.Get(keys[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if obj.GetName() != tt.obj.GetName() {
+				t.Errorf("Get() = %q, want %q", obj.GetName(), tt.obj.GetName())
+			}
+		})
+	}
+}
+
+func TestRelocateObjects(t *testing.T) {
+	dir := t.TempDir()
+	raw := NewGenericRawStorage(dir, carGVK.GroupVersion(), serializer.ContentTypeYAML)
+	s := newTestStorage(raw)
+
+	// Store the cars identified by their name only, like legacy data
+	kind := NewKindKey(carGVK)
+	for _, name := range []string{"default", "foo"} {
+		content := fmt.Sprintf("apiVersion: %s\nkind: Car\nmetadata:\n  name: %s\n  namespace: default\n", carGVK.GroupVersion(), name)
+		if err := raw.Write(NewObjectKey(kind, runtime.NewIdentifier(name)), []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	relocated, err := RelocateObjects(s, kind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(relocated) != 2 {
+		t.Errorf("expected 2 relocated objects, got %v", relocated)
+	}
+
+	keys, err := raw.List(kind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, key := range keys {
+		ids = append(ids, key.GetIdentifier())
+	}
+	if strings.Join(ids, ",") != "default/default,default/foo" {
+		t.Errorf("expected the namespaced identifiers after relocating, got %v", ids)
+	}
+
+	// Relocating again is a no-op
+	if relocated, err := RelocateObjects(s, kind); err != nil || len(relocated) != 0 {
+		t.Errorf("RelocateObjects() = %v, %v, expected nothing to be relocated", relocated, err)
+	}
+
+	// The namespace directory is removed together with its last object
+	for _, key := range keys {
+		if err := s.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Car", "default")); !os.IsNotExist(err) {
+		t.Errorf("expected the namespace directory to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Car")); err != nil {
+		t.Errorf("expected the kind directory to be kept, got %v", err)
+	}
+}
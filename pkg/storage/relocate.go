@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	log "github.com/sirupsen/logrus"
+)
+
+// RelocateObjects moves the stored Objects of the given kind whose key no longer matches the key
+// computed by the Storage's identifiers. This is the migration path for data written using other
+// identifiers, e.g. for moving objects stored by name only in GenericRawStorage (<kind>/<name>/)
+// to the namespaced layout (<kind>/<namespace>/<name>/) after switching to runtime.Metav1NameIdentifier
+// or runtime.NewScopedNameIdentifier. The content of the Objects is moved as-is, without re-encoding.
+// If the new key is already taken, ErrAlreadyExists is returned. The relocated keys are returned,
+// mapped to their new keys, also on errors.
+func RelocateObjects(s Storage, kind KindKey) (map[ObjectKey]ObjectKey, error) {
+	raw := s.RawStorage()
+	keys, err := raw.List(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	relocated := make(map[ObjectKey]ObjectKey)
+	for _, key := range keys {
+		content, err := raw.Read(key)
+		if err != nil {
+			return relocated, err
+		}
+
+		obj, err := runtime.NewPartialObject(content)
+		if err != nil {
+			return relocated, fmt.Errorf("couldn't decode %s: %w", key, err)
+		}
+		id, err := s.ObjectKeyFor(obj)
+		if err != nil {
+			return relocated, fmt.Errorf("couldn't identify %s: %w", key, err)
+		}
+
+		// Keep the kind of the listed key, only the identifier changes
+		if id.GetIdentifier() == key.GetIdentifier() {
+			continue
+		}
+		newKey := NewObjectKey(key, runtime.NewIdentifier(id.GetIdentifier()))
+		if raw.Exists(newKey) {
+			return relocated, fmt.Errorf("couldn't relocate %s to %s: %w", key, newKey, ErrAlreadyExists)
+		}
+
+		log.Debugf("RelocateObjects: Moving %s to %s", key, newKey)
+		if err := raw.Write(newKey, content); err != nil {
+			return relocated, err
+		}
+		if err := raw.Delete(key); err != nil {
+			return relocated, err
+		}
+		relocated[key] = newKey
+	}
+
+	return relocated, nil
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// statusField is the top-level field holding the status of an Object. All other top-level fields,
+// except for apiVersion, kind and metadata, are considered to be the spec of the Object.
+const statusField = "status"
+
+// hasStatus returns true if the Object has a top-level status field.
+func hasStatus(obj runtime.Object) (bool, error) {
+	content, err := toUnstructured(obj)
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := content[statusField]
+	return ok, nil
+}
+
+// specEqual returns true if the specs of the given Objects, i.e. all top-level
+// fields except for apiVersion, kind, metadata and status, are equal.
+func specEqual(a, b runtime.Object) (bool, error) {
+	aContent, err := toUnstructured(a)
+	if err != nil {
+		return false, err
+	}
+	bContent, err := toUnstructured(b)
+	if err != nil {
+		return false, err
+	}
+
+	for _, content := range []map[string]interface{}{aContent, bContent} {
+		for _, f := range []string{"apiVersion", "kind", "metadata", statusField} {
+			delete(content, f)
+		}
+	}
+	return equality.Semantic.DeepEqual(aContent, bContent), nil
+}
+
+// copyStatus replaces the status of dst with the status of src, in place.
+func copyStatus(dst, src runtime.Object) error {
+	srcContent, err := toUnstructured(src)
+	if err != nil {
+		return err
+	}
+	dstContent, err := toUnstructured(dst)
+	if err != nil {
+		return err
+	}
+
+	if status, ok := srcContent[statusField]; ok {
+		dstContent[statusField] = status
+	} else {
+		delete(dstContent, statusField)
+	}
+	return fromUnstructured(dstContent, dst)
+}
+
+// setGeneration sets metadata.generation of obj for a write replacing old. The generation
+// is bumped if the spec changed, as with the status subresource of Kubernetes.
+func setGeneration(obj, old runtime.Object) error {
+	equal, err := specEqual(obj, old)
+	if err != nil {
+		return err
+	}
+
+	generation := old.GetGeneration()
+	if !equal {
+		generation++
+	}
+	obj.SetGeneration(generation)
+	return nil
+}
+
+func toUnstructured(obj kruntime.Object) (map[string]interface{}, error) {
+	if u, ok := obj.(kruntime.Unstructured); ok {
+		return kruntime.DeepCopyJSON(u.UnstructuredContent()), nil
+	}
+
+	return kruntime.DefaultUnstructuredConverter.ToUnstructured(obj)
+}
+
+func fromUnstructured(content map[string]interface{}, obj runtime.Object) error {
+	if u, ok := obj.(kruntime.Unstructured); ok {
+		u.SetUnstructuredContent(content)
+		return nil
+	}
+
+	// Reset the Object first, as fields missing from content (e.g. omitempty ones) are otherwise kept
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("expected a pointer to an Object, got %T", obj)
+	}
+	v.Elem().Set(reflect.Zero(v.Elem().Type()))
+
+	return kruntime.DefaultUnstructuredConverter.FromUnstructured(content, obj)
+}
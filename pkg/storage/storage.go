@@ -2,18 +2,24 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/save-abandoned-projects/libgitops/pkg/filter"
 	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
 	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
 	patchutil "github.com/save-abandoned-projects/libgitops/pkg/util/patch"
+	utilsync "github.com/save-abandoned-projects/libgitops/pkg/util/sync"
 	"github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	kruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
 )
 
 var (
@@ -23,17 +29,39 @@ var (
 	ErrNotFound = errors.New("resource not found")
 	// ErrAlreadyExists is returned when when WriteStorage.Create is called for an already stored object.
 	ErrAlreadyExists = errors.New("resource already exists")
+	// ErrConflict is returned when a write was requested for a resourceVersion that is no longer the stored one.
+	ErrConflict = errors.New("resource has been modified")
+	// ErrStopWalk can be returned from a WalkFunc to stop ReadStorage.Walk early, without an error.
+	ErrStopWalk = errors.New("stop walking")
+	// ErrInvalid is returned when a write is rejected because the Object is invalid, see InvalidError.
+	ErrInvalid = errors.New("resource is invalid")
+	// ErrNoStatus is returned by UpdateStatus for Objects without a status field.
+	ErrNoStatus = errors.New("resource has no status")
 )
 
+// WalkFunc is called by ReadStorage.Walk for every Object. Return ErrStopWalk to stop the walk early.
+type WalkFunc func(obj runtime.Object) error
+
 type ReadStorage interface {
 	// Get returns a new Object for the resource at the specified kind/uid path, based on the file content.
+	// The Object's metadata.resourceVersion is set to the Checksum of the resource.
 	// If the resource referred to by the given ObjectKey does not exist, Get returns ErrNotFound.
 	Get(key ObjectKey) (runtime.Object, error)
 
 	// List lists Objects for the specific kind. Optionally, filters can be applied (see the filter package
-	// for more information, e.g. filter.NameFilter{} and filter.UIDFilter{})
+	// for more information, e.g. filter.NameFilter{} and filter.UIDFilter{}). The Objects' metadata.resourceVersion
+	// is set to the Checksum of the respective resource. The Objects are sorted by their identifier. Using
+	// filter.Limit and filter.Continue, the result can be paged through; pass ContinueToken for the key of the
+	// last returned Object to get the next page.
 	List(kind KindKey, opts ...filter.ListOption) ([]runtime.Object, error)
 
+	// Walk is the streaming equivalent of List. The Objects are decoded one-by-one, in the same order as
+	// List returns them, and fn is called for each Object passing the filters. Only the Objects being
+	// decoded are kept in memory at a time. If fn returns ErrStopWalk, the walk stops without decoding
+	// the rest of the Objects, and nil is returned. Any other error from fn, or the context being
+	// cancelled, stops the walk and is returned.
+	Walk(ctx context.Context, kind KindKey, fn WalkFunc, opts ...filter.ListOption) error
+
 	// Find does a List underneath, also using filters, but always returns one object. If the List
 	// underneath returned two or more results, ErrAmbiguousFind is returned. If no match was found,
 	// ErrNotFound is returned.
@@ -50,8 +78,9 @@ type ReadStorage interface {
 	// ListMeta lists all Objects' APIType representation. In other words,
 	// only metadata about each Object is unmarshalled (uid/name/kind/apiVersion).
 	// This allows for faster runs (no need to unmarshal "the world"), and less
-	// resource usage, when only metadata is unmarshalled into memory
-	ListMeta(kind KindKey) ([]runtime.PartialObject, error)
+	// resource usage, when only metadata is unmarshalled into memory. The options work
+	// the same way as for List.
+	ListMeta(kind KindKey, opts ...filter.ListOption) ([]runtime.PartialObject, error)
 
 	//
 	// Cache-related methods.
@@ -91,12 +120,35 @@ type WriteStorage interface {
 	Create(obj runtime.Object) error
 	// Update updates the state of the given Object in the storage. The Object must exist in the storage.
 	// The ObjectMeta.CreationTimestamp field is set automatically to the current time if it is unset.
+	// If the Object's metadata.resourceVersion is set, and it doesn't match the stored one, ErrConflict is returned.
+	// The metadata.generation is incremented if the spec, i.e. anything but the metadata and status, changed.
+	// If StatusSubresource returns true, the status of the given Object is ignored; use UpdateStatus for it.
 	Update(obj runtime.Object) error
-
-	// Patch performs a strategic merge patch on the Object with the given UID, using the byte-encoded patch given
-	Patch(key ObjectKey, patch []byte) error
-	// Delete removes an Object from the storage
-	Delete(key ObjectKey) error
+	// UpdateStatus replaces the status of the stored Object with the status of the given Object, keeping the
+	// rest of the stored Object, including its metadata.generation. If the Object's metadata.resourceVersion is
+	// set, and it doesn't match the stored one, ErrConflict is returned.
+	UpdateStatus(obj runtime.Object) error
+	// StatusSubresource returns true if Update and Patch ignore changes to the status, which can then only be
+	// changed using UpdateStatus (see WithStatusSubresource).
+	StatusSubresource() bool
+	// Apply merges the fields set in the given Object into the stored Object, creating it if it doesn't exist,
+	// like server-side apply of Kubernetes. The fields are owned by fieldManager, which is recorded in
+	// metadata.managedFields. If another manager owns a field with a different value, ErrConflict is returned,
+	// unless force is true, which takes over the ownership. Fields previously applied by fieldManager, but
+	// missing from the given Object, are removed if no other manager owns them. The given Object is updated
+	// to the stored state. Fields of typed Objects with zero values (false, 0, "", empty maps and lists) are
+	// considered unset, as they can't be told apart from omitted fields. Applying a zero value hence releases
+	// the field, which may remove it, instead of setting it. Pass a *runtime.Unstructured holding exactly the
+	// fields to set, to apply zero values.
+	Apply(obj runtime.Object, fieldManager string, force bool) error
+
+	// Patch applies the byte-encoded patch of the given type to the Object with the given key. JSON patches
+	// (types.JSONPatchType), JSON merge patches (types.MergePatchType) and strategic merge patches
+	// (types.StrategicMergePatchType) are supported. If the patch sets metadata.resourceVersion, or for JSON
+	// patches tests it, and it doesn't match the stored one, ErrConflict is returned.
+	Patch(key ObjectKey, patchType types.PatchType, patch []byte) error
+	// Delete removes an Object from the storage. If the given Preconditions aren't met, ErrConflict is returned.
+	Delete(key ObjectKey, opts ...DeleteOption) error
 }
 
 // Storage is an interface for persisting and retrieving API objects to/from a backend
@@ -107,16 +159,28 @@ type Storage interface {
 }
 
 // NewGenericStorage constructs a new Storage
-func NewGenericStorage(rawStorage RawStorage, serializer serializer.Serializer, identifiers []runtime.IdentifierFactory) Storage {
-	return &GenericStorage{rawStorage, serializer, patchutil.NewPatcher(serializer), identifiers}
+func NewGenericStorage(rawStorage RawStorage, serializer serializer.Serializer, identifiers []runtime.IdentifierFactory, optFns ...GenericStorageOptionsFunc) Storage {
+	return NewGenericStorageWithOptions(rawStorage, serializer, identifiers, MakeGenericStorageOptions(optFns...))
+}
+
+// NewGenericStorageWithOptions is like NewGenericStorage, but takes options built by MakeGenericStorageOptions.
+// This lets callers inspect the options the Storage is configured with, e.g. whether it is Unstructured.
+func NewGenericStorageWithOptions(rawStorage RawStorage, serializer serializer.Serializer, identifiers []runtime.IdentifierFactory, opts *GenericStorageOptions) Storage {
+	s := &GenericStorage{rawStorage, serializer, patchutil.NewPatcher(serializer), identifiers, *opts, &sync.Mutex{}}
+	return WithAdmission(s, opts.AdmissionHooks...)
 }
 
-// GenericStorage implements the Storage interface
+// GenericStorage implements the Storage interface. The resourceVersion of an Object is its Checksum in the
+// RawStorage. Writes are serialized, so that the resourceVersion check and the write are atomic within the
+// process. Changes made by other processes between the check and the write can't be detected.
 type GenericStorage struct {
 	raw         RawStorage
 	serializer  serializer.Serializer
 	patcher     patchutil.Patcher
 	identifiers []runtime.IdentifierFactory
+	opts        GenericStorageOptions
+	// writeMux serializes all write operations
+	writeMux *sync.Mutex
 }
 
 var _ Storage = &GenericStorage{}
@@ -127,26 +191,57 @@ func (s *GenericStorage) Serializer() serializer.Serializer {
 
 // Get returns a new Object for the resource at the specified kind/uid path, based on the file content
 func (s *GenericStorage) Get(key ObjectKey) (runtime.Object, error) {
-	content, err := s.raw.Read(key)
+	content, rv, err := s.read(key)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.decode(key, content)
+	obj, err := s.decode(key, content)
+	if err != nil {
+		return nil, err
+	}
+
+	obj.SetResourceVersion(rv)
+	return obj, nil
 }
 
 // TODO: Verify this works
 // GetMeta returns a new Object's APIType representation for the resource at the specified kind/uid path
 func (s *GenericStorage) GetMeta(key ObjectKey) (runtime.PartialObject, error) {
-	content, err := s.raw.Read(key)
+	content, rv, err := s.read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.decodeMeta(key, content)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.decodeMeta(key, content)
+	obj.SetResourceVersion(rv)
+	return obj, nil
 }
 
-// TODO: Make sure we don't save a partial object
+// read returns the content of the resource indicated by key, together with its resourceVersion.
+func (s *GenericStorage) read(key ObjectKey) ([]byte, string, error) {
+	// Compute the checksum before reading. If the resource is modified in between, the
+	// resourceVersion will be older than the content, which makes later writes conflict
+	// instead of silently overwriting the modification.
+	rv, err := s.raw.Checksum(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	content, err := s.raw.Read(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return content, rv, nil
+}
+
+// write encodes the given Object in full before handing it to the RawStorage. Partial
+// encodes are never written, and the disk-backed RawStorages replace files atomically.
 func (s *GenericStorage) write(key ObjectKey, obj runtime.Object) error {
 	// Set the content type based on the format given by the RawStorage, but default to JSON
 	contentType := serializer.ContentTypeJSON
@@ -160,13 +255,48 @@ func (s *GenericStorage) write(key ObjectKey, obj runtime.Object) error {
 		obj.SetCreationTimestamp(metav1.Now())
 	}
 
+	// The resourceVersion is derived from the stored content, don't store it
+	rv := obj.GetResourceVersion()
+	obj.SetResourceVersion("")
+	defer func() { obj.SetResourceVersion(rv) }()
+
+	// Comments are only kept if set up by preserveComments, other Objects are encoded as usual
 	var objBytes bytes.Buffer
-	err := s.serializer.Encoder().Encode(serializer.NewFrameWriter(contentType, &objBytes), obj)
+	err := s.serializer.Encoder(serializer.WithCommentsEncode(true)).Encode(serializer.NewFrameWriter(contentType, &objBytes), obj)
 	if err != nil {
 		return err
 	}
 
-	return s.raw.Write(key, objBytes.Bytes())
+	if err := s.validate(key, objBytes.Bytes()); err != nil {
+		return err
+	}
+
+	if err := s.raw.Write(key, objBytes.Bytes()); err != nil {
+		return err
+	}
+
+	// Let the caller know about the new resourceVersion
+	newRV, err := s.raw.Checksum(key)
+	if err != nil {
+		return err
+	}
+	rv = newRV
+	return nil
+}
+
+// checkResourceVersion returns ErrConflict if rv is set, and isn't the stored resourceVersion
+// of the resource indicated by key. If the resource doesn't exist, ErrNotFound is returned.
+func (s *GenericStorage) checkResourceVersion(key ObjectKey, rv string) error {
+	if !s.raw.Exists(key) {
+		return ErrNotFound
+	}
+
+	currentRV, err := s.raw.Checksum(key)
+	if err != nil {
+		return err
+	}
+
+	return Preconditions{ResourceVersion: rv}.Check(key, currentRV)
 }
 
 func (s *GenericStorage) Create(obj runtime.Object) error {
@@ -175,10 +305,18 @@ func (s *GenericStorage) Create(obj runtime.Object) error {
 		return err
 	}
 
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
 	if s.raw.Exists(key) {
 		return ErrAlreadyExists
 	}
 
+	// The generation of new objects starts at 1
+	if obj.GetGeneration() == 0 {
+		obj.SetGeneration(1)
+	}
+
 	// The object was not found so we can safely create it
 	return s.write(key, obj)
 }
@@ -189,75 +327,194 @@ func (s *GenericStorage) Update(obj runtime.Object) error {
 		return err
 	}
 
-	if !s.raw.Exists(key) {
-		return ErrNotFound
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
+	if err := s.checkResourceVersion(key, obj.GetResourceVersion()); err != nil {
+		return err
+	}
+
+	old, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+	if err := s.prepareUpdate(obj, old); err != nil {
+		return err
+	}
+	if err := s.updateManagedFields(key, obj, old); err != nil {
+		return err
 	}
 
-	// The object was found so we can safely update it
+	// The object was found with the expected version so we can safely update it
 	return s.write(key, obj)
 }
 
-// Patch performs a strategic merge patch on the object with the given UID, using the byte-encoded patch given
-func (s *GenericStorage) Patch(key ObjectKey, patch []byte) error {
-	oldContent, err := s.raw.Read(key)
+// UpdateStatus replaces the status of the stored Object with the status of the given Object. The
+// given Object is updated to the stored state, i.e. its spec and metadata are replaced too.
+func (s *GenericStorage) UpdateStatus(obj runtime.Object) error {
+	key, err := s.ObjectKeyFor(obj)
 	if err != nil {
 		return err
 	}
 
-	newContent, err := s.patcher.Apply(oldContent, patch, key.GetGVK())
-	if err != nil {
+	if ok, err := hasStatus(obj); err != nil {
 		return err
+	} else if !ok {
+		return fmt.Errorf("%s: %w", key, ErrNoStatus)
 	}
 
-	return s.raw.Write(key, newContent)
-}
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
 
-// Delete removes an Object from the storage
-func (s *GenericStorage) Delete(key ObjectKey) error {
-	return s.raw.Delete(key)
+	if err := s.checkResourceVersion(key, obj.GetResourceVersion()); err != nil {
+		return err
+	}
+
+	stored, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+	if err := copyStatus(stored, obj); err != nil {
+		return err
+	}
+	if err := s.write(key, stored); err != nil {
+		return err
+	}
+
+	// Let the caller know about the stored state
+	content, err := toUnstructured(stored)
+	if err != nil {
+		return err
+	}
+	return fromUnstructured(content, obj)
 }
 
-// Checksum returns a string representing the state of an Object on disk
-func (s *GenericStorage) Checksum(key ObjectKey) (string, error) {
-	return s.raw.Checksum(key)
+// StatusSubresource returns true if the StatusSubresource option is set.
+func (s *GenericStorage) StatusSubresource() bool {
+	return *s.opts.StatusSubresource
 }
 
-func (s *GenericStorage) list(kind KindKey) (result []runtime.Object, walkerr error) {
-	walkerr = s.walkKind(kind, func(key ObjectKey, content []byte) error {
-		obj, err := s.decode(key, content)
-		if err != nil {
+// prepareUpdate sets the metadata.generation of obj for replacing old, and keeps
+// the status of old if the StatusSubresource option is set.
+func (s *GenericStorage) prepareUpdate(obj, old runtime.Object) error {
+	if *s.opts.StatusSubresource {
+		if err := copyStatus(obj, old); err != nil {
 			return err
 		}
+	}
 
-		result = append(result, obj)
-		return nil
-	})
-	return
+	return setGeneration(obj, old)
 }
 
-// List lists Objects for the specific kind. Optionally, filters can be applied (see the filter package
-// for more information, e.g. filter.NameFilter{} and filter.UIDFilter{})
-func (s *GenericStorage) List(kind KindKey, opts ...filter.ListOption) ([]runtime.Object, error) {
-	// First, complete the options struct
-	o, err := filter.MakeListOptions(opts...)
+// Patch applies the byte-encoded patch of the given type to the object with the given key. The patch is
+// applied to the decoded object, which is then re-encoded in the content type of the key. For YAML, the
+// comments of the stored content are kept.
+func (s *GenericStorage) Patch(key ObjectKey, patchType types.PatchType, patch []byte) error {
+	rv, patch, err := resourceVersionFromPatch(patchType, patch)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Do an internal list to get all objects
-	objs, err := s.list(kind)
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
+	if err := s.checkResourceVersion(key, rv); err != nil {
+		return err
+	}
+
+	oldContent, err := s.raw.Read(key)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	old, err := s.decode(key, oldContent)
+	if err != nil {
+		return err
 	}
 
-	// For all list filters, pipe the output of the previous as the input to the next, in order.
-	for _, filter := range o.Filters {
-		objs, err = filter.Filter(objs...)
-		if err != nil {
-			return nil, err
-		}
+	// The patches are applied to JSON, regardless of the stored format
+	var oldJSON bytes.Buffer
+	if err := s.serializer.Encoder().Encode(serializer.NewJSONFrameWriter(&oldJSON), old); err != nil {
+		return err
 	}
-	return objs, nil
+
+	newJSON, err := s.patcher.Apply(oldJSON.Bytes(), patch, patchType, key.GetGVK())
+	if err != nil {
+		return err
+	}
+
+	obj, err := s.decodeAs(key, serializer.ContentTypeJSON, newJSON)
+	if err != nil {
+		return err
+	}
+	if err := s.prepareUpdate(obj, old); err != nil {
+		return err
+	}
+	if err := s.updateManagedFields(key, obj, old); err != nil {
+		return err
+	}
+	if err := s.preserveComments(key, obj, oldContent); err != nil {
+		return err
+	}
+
+	return s.write(key, obj)
+}
+
+// preserveComments makes write keep the comments of the given stored content in the
+// encoded Object, if the key's content type is YAML. See serializer.SetCommentSource.
+func (s *GenericStorage) preserveComments(key ObjectKey, obj runtime.Object, content []byte) error {
+	if s.raw.ContentType(key) != serializer.ContentTypeYAML {
+		return nil
+	}
+
+	source, err := yaml.Parse(string(content))
+	if err != nil {
+		return err
+	}
+
+	return serializer.SetCommentSource(obj, source)
+}
+
+// validate returns an InvalidError if the SchemaValidator option is set, and
+// the given content doesn't match the schema of the Object's type.
+func (s *GenericStorage) validate(key ObjectKey, content []byte) error {
+	if s.opts.SchemaValidator == nil {
+		return nil
+	}
+
+	if errs := s.opts.SchemaValidator.Validate(content); len(errs) != 0 {
+		return &InvalidError{Key: key, Errors: errs}
+	}
+	return nil
+}
+
+// Delete removes an Object from the storage
+func (s *GenericStorage) Delete(key ObjectKey, opts ...DeleteOption) error {
+	o := MakeDeleteOptions(opts...)
+
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
+	if err := s.checkResourceVersion(key, o.Preconditions.ResourceVersion); err != nil {
+		return err
+	}
+
+	return s.raw.Delete(key)
+}
+
+// Checksum returns a string representing the state of an Object on disk
+func (s *GenericStorage) Checksum(key ObjectKey) (string, error) {
+	return s.raw.Checksum(key)
+}
+
+// List lists Objects for the specific kind. Optionally, filters can be applied (see the filter package
+// for more information, e.g. filter.NameFilter{} and filter.UIDFilter{})
+func (s *GenericStorage) List(kind KindKey, opts ...filter.ListOption) ([]runtime.Object, error) {
+	return s.list(kind, opts, s.decode)
+}
+
+// Walk decodes the Objects of the given kind one-by-one, and calls fn for each Object passing the filters.
+func (s *GenericStorage) Walk(ctx context.Context, kind KindKey, fn WalkFunc, opts ...filter.ListOption) error {
+	return s.walk(ctx, kind, opts, s.decode, fn)
 }
 
 // Find does a List underneath, also using filters, but always returns one object. If the List
@@ -284,20 +541,104 @@ func (s *GenericStorage) Find(kind KindKey, opts ...filter.ListOption) (runtime.
 // only metadata about each Object is unmarshalled (uid/name/kind/apiVersion).
 // This allows for faster runs (no need to unmarshal "the world"), and less
 // resource usage, when only metadata is unmarshalled into memory
-func (s *GenericStorage) ListMeta(kind KindKey) (result []runtime.PartialObject, walkerr error) {
-	walkerr = s.walkKind(kind, func(key ObjectKey, content []byte) error {
+func (s *GenericStorage) ListMeta(kind KindKey, opts ...filter.ListOption) ([]runtime.PartialObject, error) {
+	objs, err := s.list(kind, opts, func(key ObjectKey, content []byte) (runtime.Object, error) {
+		return s.decodeMeta(key, content)
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		obj, err := s.decodeMeta(key, content)
-		if err != nil {
-			return err
-		}
+	result := make([]runtime.PartialObject, 0, len(objs))
+	for _, obj := range objs {
+		result = append(result, obj.(runtime.PartialObject))
+	}
+	return result, nil
+}
 
+// list collects all Objects walked by walk into a slice.
+func (s *GenericStorage) list(kind KindKey, opts []filter.ListOption, decodeFn decodeFunc) (result []runtime.Object, err error) {
+	err = s.walk(context.Background(), kind, opts, decodeFn, func(obj runtime.Object) error {
 		result = append(result, obj)
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 	return
 }
 
+// decodeFunc decodes the given content of the resource indicated by key.
+type decodeFunc func(key ObjectKey, content []byte) (runtime.Object, error)
+
+// walk walks the Objects of the given kind in order, and decodes them using decodeFn in the configured
+// amount of workers. The decoded Objects are piped through the filters one-by-one, in order, and fn is
+// called for the ones left. The walk stops as soon as the requested amount of Objects is found.
+func (s *GenericStorage) walk(ctx context.Context, kind KindKey, opts []filter.ListOption, decodeFn decodeFunc, fn WalkFunc) error {
+	// First, complete the options struct
+	o, err := filter.MakeListOptions(opts...)
+	if err != nil {
+		return err
+	}
+
+	keys, err := ListKeys(s.raw, kind, o.Continue)
+	if err != nil {
+		return err
+	}
+
+	// Read and decode the Objects in the workers
+	process := func(i int) (runtime.Object, error) {
+		// Allow metadata.json to not exist, although the directory does exist
+		if !s.raw.Exists(keys[i]) {
+			return nil, nil
+		}
+
+		content, rv, err := s.read(keys[i])
+		if err != nil {
+			return nil, err
+		}
+
+		obj, err := decodeFn(keys[i], content)
+		if err != nil {
+			return nil, err
+		}
+		obj.SetResourceVersion(rv)
+		return obj, nil
+	}
+
+	var count int64
+	err = utilsync.ForEachOrdered(ctx, len(keys), *s.opts.DecodeWorkers, process, func(obj runtime.Object) error {
+		if obj == nil {
+			return nil
+		}
+
+		// For all list filters, pipe the output of the previous as the input to the next, in order.
+		objs := []runtime.Object{obj}
+		for _, filter := range o.Filters {
+			if objs, err = filter.Filter(objs...); err != nil {
+				return err
+			}
+		}
+
+		for _, obj := range objs {
+			if err := fn(obj); err != nil {
+				return err
+			}
+
+			// Stop walking if we've got all the Objects that were asked for
+			count++
+			if o.Limit > 0 && count >= o.Limit {
+				return ErrStopWalk
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, ErrStopWalk) {
+		return nil
+	}
+	return err
+}
+
 // Count counts the Objects for the specific kind
 func (s *GenericStorage) Count(kind KindKey) (uint64, error) {
 	entries, err := s.raw.List(kind)
@@ -349,20 +690,30 @@ func (s *GenericStorage) identify(obj runtime.Object) runtime.Identifyable {
 }
 
 func (s *GenericStorage) decode(key ObjectKey, content []byte) (runtime.Object, error) {
+	return s.decodeAs(key, s.raw.ContentType(key), content)
+}
+
+// decodeAs decodes content of the given content type, instead of the content type of the key
+func (s *GenericStorage) decodeAs(key ObjectKey, ct serializer.ContentType, content []byte) (runtime.Object, error) {
 	gvk := key.GetGVK()
 	// Decode the bytes to the internal version of the Object, if desired
 	isInternal := gvk.Version == kruntime.APIVersionInternal
 
 	// Decode the bytes into an Object
-	ct := s.raw.ContentType(key)
 	logrus.Infof("Decoding with content type %s", ct)
 	obj, err := s.serializer.Decoder(
 		serializer.WithConvertToHubDecode(isInternal),
+		serializer.WithUnstructuredDecode(*s.opts.Unstructured),
 	).Decode(serializer.NewFrameReader(ct, serializer.FromBytes(content)))
 	if err != nil {
 		return nil, err
 	}
 
+	// Kinds unknown to the scheme are decoded as unstructured Objects, if enabled
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		obj = runtime.NewUnstructured(u)
+	}
+
 	// Cast to runtime.Object, and make sure it works
 	metaObj, ok := obj.(runtime.Object)
 	if !ok {
@@ -375,8 +726,14 @@ func (s *GenericStorage) decode(key ObjectKey, content []byte) (runtime.Object,
 }
 
 func (s *GenericStorage) decodeMeta(key ObjectKey, content []byte) (runtime.PartialObject, error) {
+	// Don't check that the kind is known to the scheme, if unknown kinds are handled as unstructured Objects
+	scheme := s.serializer.Scheme()
+	if *s.opts.Unstructured {
+		scheme = nil
+	}
+
 	gvk := key.GetGVK()
-	partobjs, err := DecodePartialObjects(serializer.FromBytes(content), s.serializer.Scheme(), false, &gvk)
+	partobjs, err := DecodePartialObjects(serializer.FromBytes(content), scheme, false, &gvk)
 	if err != nil {
 		return nil, err
 	}
@@ -384,34 +741,9 @@ func (s *GenericStorage) decodeMeta(key ObjectKey, content []byte) (runtime.Part
 	return partobjs[0], nil
 }
 
-func (s *GenericStorage) walkKind(kind KindKey, fn func(key ObjectKey, content []byte) error) error {
-	keys, err := s.raw.List(kind)
-	if err != nil {
-		return err
-	}
-
-	for _, key := range keys {
-		// Allow metadata.json to not exist, although the directory does exist
-		if !s.raw.Exists(key) {
-			continue
-		}
-
-		content, err := s.raw.Read(key)
-		if err != nil {
-			return err
-		}
-
-		if err := fn(key, content); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
 // DecodePartialObjects reads any set of frames from the given ReadCloser, decodes the frames into
 // PartialObjects, validates that the decoded objects are known to the scheme, and optionally sets a default
-// group
+// group. If scheme is nil, objects of any kind are decoded.
 func DecodePartialObjects(rc io.ReadCloser, scheme *kruntime.Scheme, allowMultiple bool, defaultGVK *schema.GroupVersionKind) ([]runtime.PartialObject, error) {
 	fr := serializer.NewYAMLFrameReader(rc)
 
@@ -435,7 +767,7 @@ func DecodePartialObjects(rc io.ReadCloser, scheme *kruntime.Scheme, allowMultip
 		gvk := partobj.GetObjectKind().GroupVersionKind()
 
 		// Don't decode API objects unknown to the scheme (e.g. Kubernetes manifests)
-		if !scheme.Recognizes(gvk) {
+		if scheme != nil && !scheme.Recognizes(gvk) {
 			// TODO: Typed error
 			return nil, fmt.Errorf("unknown GroupVersionKind: %s", partobj.GetObjectKind().GroupVersionKind())
 		}
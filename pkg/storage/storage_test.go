@@ -0,0 +1,624 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/save-abandoned-projects/libgitops/api/openapi"
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/save-abandoned-projects/libgitops/pkg/filter"
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/kube-openapi/pkg/common"
+)
+
+func TestGenericStorage_ResourceVersion(t *testing.T) {
+	s := newTestStorage(NewMemoryRawStorage(serializer.ContentTypeJSON))
+	key := NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/foo"))
+
+	car := newTestCar("foo", "Acura")
+	if err := s.Create(car); err != nil {
+		t.Fatal(err)
+	}
+	if len(car.ResourceVersion) == 0 {
+		t.Fatal("expected Create to set the resourceVersion")
+	}
+
+	// Two writers read the same version of the object
+	obj1, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj2, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rv := obj1.GetResourceVersion(); rv != car.ResourceVersion {
+		t.Errorf("expected resourceVersion %q, got %q", car.ResourceVersion, rv)
+	}
+
+	// The first one wins, the second one conflicts
+	obj1.(*v1alpha1.Car).Spec.Brand = "Volvo"
+	if err := s.Update(obj1); err != nil {
+		t.Fatal(err)
+	}
+	obj2.(*v1alpha1.Car).Spec.Brand = "Saab"
+	if err := s.Update(obj2); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+
+	// Patches and deletes with a stale resourceVersion conflict as well
+	stale := obj2.GetResourceVersion()
+	patch := []byte(`{"metadata":{"resourceVersion":"` + stale + `"},"spec":{"brand":"Saab"}}`)
+	if err := s.Patch(key, types.StrategicMergePatchType, patch); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+	jsonPatch := []byte(`[{"op":"test","path":"/metadata/resourceVersion","value":"` + stale + `"},{"op":"replace","path":"/spec/brand","value":"Saab"}]`)
+	if err := s.Patch(key, types.JSONPatchType, jsonPatch); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for the JSON patch, got %v", err)
+	}
+	if err := s.Delete(key, Preconditions{ResourceVersion: stale}); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+
+	// Patching with the current resourceVersion works, and doesn't store it
+	patch = []byte(`{"metadata":{"resourceVersion":"` + obj1.GetResourceVersion() + `"},"spec":{"brand":"Saab"}}`)
+	if err := s.Patch(key, types.StrategicMergePatchType, patch); err != nil {
+		t.Fatal(err)
+	}
+	content, err := s.RawStorage().Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rv, _, err := resourceVersionFromPatch(types.MergePatchType, content); err != nil || len(rv) != 0 {
+		t.Errorf("expected no stored resourceVersion, got %q, %v", rv, err)
+	}
+
+	if err := s.Delete(key, Preconditions{ResourceVersion: obj1.GetResourceVersion()}); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict after the patch, got %v", err)
+	}
+	if err := s.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenericStorage_ListPagination(t *testing.T) {
+	s := newTestStorage(NewMemoryRawStorage(serializer.ContentTypeYAML))
+	kind := NewKindKey(carGVK)
+
+	// Create the objects out of order, List must sort them
+	for _, name := range []string{"e", "b", "d", "a", "c"} {
+		brand := "Volvo"
+		if name == "c" {
+			brand = "Saab"
+		}
+		if err := s.Create(newTestCar(name, brand)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var pages [][]string
+	var token string
+	for {
+		objs, err := s.List(kind, filter.Limit(2), filter.Continue(token), filter.FieldFilter{
+			Path:     "spec.brand",
+			Operator: filter.FieldOpEquals,
+			Values:   []string{"Volvo"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objs) == 0 {
+			break
+		}
+
+		var page []string
+		for _, obj := range objs {
+			page = append(page, obj.GetName())
+		}
+		pages = append(pages, page)
+
+		key, err := s.ObjectKeyFor(objs[len(objs)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		token = ContinueToken(key)
+	}
+
+	want := [][]string{{"a", "b"}, {"d", "e"}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("expected pages %v, got %v", want, pages)
+	}
+
+	metas, err := s.ListMeta(kind, filter.Limit(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 3 || metas[2].GetName() != "c" {
+		t.Errorf("expected the first three objects, got %v", metas)
+	}
+
+	if _, err := s.List(kind, filter.Continue("!")); !errors.Is(err, filter.ErrInvalidFilterParams) {
+		t.Errorf("expected ErrInvalidFilterParams for an invalid token, got %v", err)
+	}
+}
+
+func TestGenericStorage_Walk(t *testing.T) {
+	s := newTestStorage(NewMemoryRawStorage(serializer.ContentTypeYAML))
+	kind := NewKindKey(carGVK)
+
+	for _, name := range []string{"c", "a", "b"} {
+		if err := s.Create(newTestCar(name, "Volvo")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Stop the walk after the second Object
+	var names []string
+	err := s.Walk(context.Background(), kind, func(obj runtime.Object) error {
+		names = append(names, obj.GetName())
+		if len(names) == 2 {
+			return ErrStopWalk
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+
+	// Errors from the WalkFunc are returned as-is
+	errTest := errors.New("test")
+	if err := s.Walk(context.Background(), kind, func(runtime.Object) error { return errTest }); err != errTest {
+		t.Errorf("expected the WalkFunc error, got %v", err)
+	}
+
+	// A cancelled context stops the walk before the next Object
+	ctx, cancel := context.WithCancel(context.Background())
+	count := 0
+	err = s.Walk(ctx, kind, func(runtime.Object) error {
+		count++
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || count != 1 {
+		t.Errorf("expected context.Canceled after one Object, got %v after %d", err, count)
+	}
+}
+
+func TestGenericStorage_DecodeWorkers(t *testing.T) {
+	raw := NewMemoryRawStorage(serializer.ContentTypeYAML)
+	kind := NewKindKey(carGVK)
+
+	var want []string
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("car-%02d", i)
+		want = append(want, name)
+		if err := newTestStorage(raw).Create(newTestCar(name, "Volvo")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			s := NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}, WithDecodeWorkers(workers))
+
+			objs, err := s.List(kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, obj := range objs {
+				got = append(got, obj.GetName())
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+
+			metas, err := s.ListMeta(kind, filter.Limit(10))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(metas) != 10 || metas[9].GetName() != want[9] {
+				t.Errorf("expected the first ten objects, got %v", metas)
+			}
+		})
+	}
+
+	// A broken object fails the List, regardless of the amount of workers
+	if err := raw.Write(NewObjectKey(kind, runtime.NewIdentifier("default/car-25")), []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	s := NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}, WithDecodeWorkers(4))
+	if _, err := s.List(kind); err == nil {
+		t.Error("expected an error for the broken object")
+	}
+}
+
+func BenchmarkGenericStorage_List(b *testing.B) {
+	logrus.SetLevel(logrus.WarnLevel)
+	defer logrus.SetLevel(logrus.InfoLevel)
+
+	raw := NewMemoryRawStorage(serializer.ContentTypeYAML)
+	for i := 0; i < 2000; i++ {
+		if err := newTestStorage(raw).Create(newTestCar(fmt.Sprintf("car-%04d", i), "Volvo")); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		s := NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}, WithDecodeWorkers(workers))
+		b.Run(fmt.Sprintf("List/%d workers", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := s.List(NewKindKey(carGVK)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("ListMeta/%d workers", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := s.ListMeta(NewKindKey(carGVK)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestGenericStorage_SchemaValidation(t *testing.T) {
+	// Only allow known brands, on top of the generated definitions
+	getDefinitions := func(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+		defs := openapi.GetOpenAPIDefinitions(ref)
+		name := "github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/v1alpha1.CarSpec"
+		brand := defs[name].Schema.Properties["brand"]
+		brand.Enum = []interface{}{"Volvo", "Saab"}
+		defs[name].Schema.Properties["brand"] = brand
+		return defs
+	}
+
+	s := NewGenericStorage(NewMemoryRawStorage(serializer.ContentTypeJSON), scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+		WithSchemaValidation(serializer.NewSchemaValidator(scheme.Scheme, getDefinitions)))
+	key := NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/foo"))
+
+	tests := []struct {
+		name    string
+		write   func() error
+		wantErr bool
+	}{
+		{
+			name:  "valid create",
+			write: func() error { return s.Create(newTestCar("foo", "Volvo")) },
+		},
+		{
+			name:    "invalid update",
+			write:   func() error { return s.Update(newTestCar("foo", "Acura")) },
+			wantErr: true,
+		},
+		{
+			name:    "invalid patch",
+			write:   func() error { return s.Patch(key, types.StrategicMergePatchType, []byte(`{"spec":{"brand":"Acura"}}`)) },
+			wantErr: true,
+		},
+		{
+			name:  "valid patch",
+			write: func() error { return s.Patch(key, types.StrategicMergePatchType, []byte(`{"spec":{"brand":"Saab"}}`)) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.write()
+			var invalid *InvalidError
+			if !tt.wantErr && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr && (!errors.As(err, &invalid) || len(invalid.Errors) != 1 || invalid.Errors[0].Field != "spec.brand") {
+				t.Fatalf("expected an InvalidError for spec.brand, got %v", err)
+			}
+		})
+	}
+
+	// The invalid writes weren't stored
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brand := obj.(*v1alpha1.Car).Spec.Brand; brand != "Saab" {
+		t.Errorf("expected brand Saab, got %q", brand)
+	}
+}
+
+func TestGenericStorage_Status(t *testing.T) {
+	key := NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/foo"))
+
+	tests := []struct {
+		name              string
+		statusSubresource bool
+		write             func(s Storage) error
+		wantGeneration    int64
+		wantBrand         string
+		wantSpeed         float64
+	}{
+		{
+			name:           "status update keeps the generation",
+			write:          func(s Storage) error { return s.Update(newTestCarWithSpeed("Volvo", 10)) },
+			wantGeneration: 1,
+			wantBrand:      "Volvo",
+			wantSpeed:      10,
+		},
+		{
+			name:           "spec update bumps the generation",
+			write:          func(s Storage) error { return s.Update(newTestCarWithSpeed("Saab", 10)) },
+			wantGeneration: 2,
+			wantBrand:      "Saab",
+			wantSpeed:      10,
+		},
+		{
+			name:           "UpdateStatus only replaces the status",
+			write:          func(s Storage) error { return s.UpdateStatus(newTestCarWithSpeed("Saab", 10)) },
+			wantGeneration: 1,
+			wantBrand:      "Volvo",
+			wantSpeed:      10,
+		},
+		{
+			name:              "Update ignores the status with a status subresource",
+			statusSubresource: true,
+			write:             func(s Storage) error { return s.Update(newTestCarWithSpeed("Saab", 10)) },
+			wantGeneration:    2,
+			wantBrand:         "Saab",
+			wantSpeed:         5,
+		},
+		{
+			name:              "Patch ignores the status with a status subresource",
+			statusSubresource: true,
+			write: func(s Storage) error {
+				return s.Patch(key, types.StrategicMergePatchType, []byte(`{"status":{"speed":10}}`))
+			},
+			wantGeneration: 1,
+			wantBrand:      "Volvo",
+			wantSpeed:      5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewGenericStorage(NewMemoryRawStorage(serializer.ContentTypeJSON), scheme.Serializer,
+				[]runtime.IdentifierFactory{runtime.Metav1NameIdentifier}, WithStatusSubresource(tt.statusSubresource))
+			if err := s.Create(newTestCarWithSpeed("Volvo", 5)); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.write(s); err != nil {
+				t.Fatal(err)
+			}
+
+			obj, err := s.Get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			car := obj.(*v1alpha1.Car)
+			if car.Generation != tt.wantGeneration {
+				t.Errorf("expected generation %d, got %d", tt.wantGeneration, car.Generation)
+			}
+			if car.Spec.Brand != tt.wantBrand {
+				t.Errorf("expected brand %q, got %q", tt.wantBrand, car.Spec.Brand)
+			}
+			if car.Status.Speed != tt.wantSpeed {
+				t.Errorf("expected speed %v, got %v", tt.wantSpeed, car.Status.Speed)
+			}
+		})
+	}
+}
+
+func newTestCarWithSpeed(brand string, speed float64) *v1alpha1.Car {
+	car := newTestCar("foo", brand)
+	car.Status.Speed = speed
+	return car
+}
+
+func TestGenericStorage_PatchPreservesComments(t *testing.T) {
+	content := `# The car of foo
+apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: foo
+  namespace: default
+spec:
+  brand: Volvo # Swedish
+  engine: V8
+status:
+  acceleration: 0
+  distance: 0
+  persons: 0
+  speed: 0
+`
+	key := NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/foo"))
+
+	tests := []struct {
+		name      string
+		patchType types.PatchType
+		patch     string
+		admission bool
+	}{
+		{
+			name:      "strategic merge patch",
+			patchType: types.StrategicMergePatchType,
+			patch:     `{"spec":{"engine":"V6"}}`,
+		},
+		{
+			name:      "merge patch",
+			patchType: types.MergePatchType,
+			patch:     `{"spec":{"engine":"V6"}}`,
+		},
+		{
+			name:      "JSON patch",
+			patchType: types.JSONPatchType,
+			patch:     `[{"op":"replace","path":"/spec/engine","value":"V6"}]`,
+		},
+		{
+			name:      "patch with admission",
+			patchType: types.MergePatchType,
+			patch:     `{"spec":{"engine":"V6"}}`,
+			admission: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := NewMemoryRawStorage(serializer.ContentTypeYAML)
+			if err := raw.Write(key, []byte(content)); err != nil {
+				t.Fatal(err)
+			}
+			s := newTestStorage(raw)
+			if tt.admission {
+				s = WithAdmission(s, NewValidatingHook(func(AdmissionAttributes) field.ErrorList { return nil }))
+			}
+
+			if err := s.Patch(key, tt.patchType, []byte(tt.patch)); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := raw.Read(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{"# The car of foo\n", "brand: Volvo # Swedish\n", "engine: V6\n"} {
+				if !strings.Contains(string(result), want) {
+					t.Errorf("expected %q in the patched content:\n%s", want, result)
+				}
+			}
+			if strings.Contains(string(result), "annotations") {
+				t.Errorf("expected no annotations in the patched content:\n%s", result)
+			}
+		})
+	}
+}
+
+func TestGenericStorage_Unstructured(t *testing.T) {
+	content := `# The settings of foo
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  labels:
+    app: foo
+  name: foo
+  namespace: default
+data:
+  color: blue # the default
+`
+	cmGVK := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	kind := NewKindKey(cmGVK)
+	key := NewObjectKey(kind, runtime.NewIdentifier("default/foo"))
+
+	raw := NewMemoryRawStorage(serializer.ContentTypeYAML)
+	if err := raw.Write(key, []byte(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Kinds unknown to the scheme can't be read by default
+	if _, err := newTestStorage(raw).Get(key); err == nil {
+		t.Fatal("expected an error for an unknown kind")
+	}
+
+	s := NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}, WithUnstructured(true))
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, ok := obj.(*runtime.Unstructured)
+	if !ok {
+		t.Fatalf("expected *runtime.Unstructured, got %T", obj)
+	}
+	if color, _, _ := unstructured.NestedString(u.Object, "data", "color"); color != "blue" {
+		t.Errorf("expected color blue, got %q", color)
+	}
+	meta, err := s.GetMeta(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.GetName() != "foo" {
+		t.Errorf("expected name foo, got %q", meta.GetName())
+	}
+
+	// Typed Objects are still decoded into their types
+	if err := s.Create(newTestCar("bar", "Volvo")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/bar"))); err != nil {
+		t.Fatal(err)
+	}
+
+	bar := runtime.NewUnstructured(&unstructured.Unstructured{})
+	bar.SetGroupVersionKind(cmGVK)
+	bar.SetName("bar")
+	bar.SetNamespace("default")
+	if err := unstructured.SetNestedField(bar.Object, "red", "data", "color"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(bar); err != nil {
+		t.Fatal(err)
+	}
+
+	objs, err := s.List(kind, filter.FieldFilter{Path: "data.color", Operator: filter.FieldOpEquals, Values: []string{"red"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].GetName() != "bar" {
+		t.Errorf("expected only bar to match the field filter, got %v", objs)
+	}
+	objs, err = s.List(kind, filter.LabelSelectorFilter{Selector: "app=foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].GetName() != "foo" {
+		t.Errorf("expected only foo to match the label filter, got %v", objs)
+	}
+
+	if err := s.Patch(key, types.MergePatchType, []byte(`{"data":{"color":"green"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	result, err := raw.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# The settings of foo\n", "color: green # the default\n"} {
+		if !strings.Contains(string(result), want) {
+			t.Errorf("expected %q in the patched content:\n%s", want, result)
+		}
+	}
+	if strings.Contains(string(result), "annotations") {
+		t.Errorf("expected no annotations in the patched content:\n%s", result)
+	}
+
+	if err := unstructured.SetNestedField(u.Object, "large", "data", "size"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update(u); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for an outdated Object, got %v", err)
+	}
+	u.SetResourceVersion("")
+	if err := s.Update(u); err != nil {
+		t.Fatal(err)
+	}
+	if obj, err = s.Get(key); err != nil {
+		t.Fatal(err)
+	}
+	if size, _, _ := unstructured.NestedString(obj.(*runtime.Unstructured).Object, "data", "size"); size != "large" {
+		t.Errorf("expected size large, got %q", size)
+	}
+
+	if err := s.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := s.Count(kind); err != nil || count != 1 {
+		t.Errorf("expected 1 ConfigMap left, got %d (%v)", count, err)
+	}
+}
@@ -1,18 +1,17 @@
 package sync
 
-/*
-
-TODO: Revisit if we need this file/package in the future.
-
 import (
+	"errors"
 	"fmt"
+	gosync "sync"
 
-	log "github.com/sirupsen/logrus"
 	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
 	"github.com/save-abandoned-projects/libgitops/pkg/storage"
-	"github.com/save-abandoned-projects/libgitops/pkg/storage/watch"
 	"github.com/save-abandoned-projects/libgitops/pkg/storage/watch/update"
 	"github.com/save-abandoned-projects/libgitops/pkg/util/sync"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
 const updateBuffer = 4096 // How many updates to buffer, 4096 should be enough for even a high update frequency
@@ -23,9 +22,18 @@ const updateBuffer = 4096 // How many updates to buffer, 4096 should be enough f
 // one). For any retrieval or generation operation, the embedded Storage
 // will be used (it is treated as read-write). As all other Storages only
 // receive write operations, they can be thought of as write-only.
+//
+// The read-write Storage is written first, so that it can check the preconditions
+// (e.g. the resourceVersion) of the write. If that succeeds, the resulting Object is
+// written to all write-only Storages in parallel, creating it if it doesn't exist
+// there. The errors of all Storages are aggregated.
+//
+// If any of the Storages is an update.EventStorage, the changes it reports (e.g. files
+// edited outside of the program) are mirrored to all other Storages, and forwarded to
+// the UpdateStream given to SetUpdateStream.
 type SyncStorage struct {
 	storage.Storage
-	storages       []storage.Storage
+	wStorages      []storage.Storage
 	inboundStream  update.UpdateStream
 	outboundStream update.UpdateStream
 	monitor        *sync.Monitor
@@ -35,100 +43,184 @@ type SyncStorage struct {
 var _ update.EventStorage = &SyncStorage{}
 
 // NewSyncStorage constructs a new SyncStorage
-func NewSyncStorage(rwStorage storage.Storage, wStorages ...storage.Storage) storage.Storage {
+func NewSyncStorage(rwStorage storage.Storage, wStorages ...storage.Storage) update.EventStorage {
 	ss := &SyncStorage{
-		Storage:  rwStorage,
-		storages: append(wStorages, rwStorage),
+		Storage:   rwStorage,
+		wStorages: wStorages,
 	}
 
-	for _, s := range ss.storages {
-		if watchStorage, ok := s.(watch.WatchStorage); ok {
-			// Populate eventStream if we found a watchstorage
+	for _, s := range ss.storages() {
+		if eventStorage, ok := s.(update.EventStorage); ok {
+			// Populate inboundStream if we found an EventStorage
 			if ss.inboundStream == nil {
 				ss.inboundStream = make(update.UpdateStream, updateBuffer)
 			}
-			watchStorage.SetUpdateStream(ss.inboundStream)
+			eventStorage.SetUpdateStream(ss.inboundStream)
 		}
 	}
 
 	if ss.inboundStream != nil {
 		ss.monitor = sync.RunMonitor(ss.monitorFunc)
-		ss.outboundStream = make(update.UpdateStream, updateBuffer)
 	}
 
 	return ss
 }
 
-// Set is propagated to all Storages
-func (ss *SyncStorage) Set(obj runtime.Object) error {
-	return ss.runAll(func(s storage.Storage) error {
-		return s.Set(obj)
-	})
+// storages returns all Storages, the read-write one first
+func (ss *SyncStorage) storages() []storage.Storage {
+	return append([]storage.Storage{ss.Storage}, ss.wStorages...)
 }
 
-// Patch is propagated to all Storages
-func (ss *SyncStorage) Patch(key storage.ObjectKey, patch []byte) error {
-	return ss.runAll(func(s storage.Storage) error {
-		return s.Patch(key, patch)
-	})
+// Create is propagated to all Storages
+func (ss *SyncStorage) Create(obj runtime.Object) error {
+	if err := ss.Storage.Create(obj); err != nil {
+		return fmt.Errorf("SyncStorage: error in the read-write Storage: %w", err)
+	}
+
+	return ss.setAll(obj, ss.wStorages)
 }
 
-// Delete is propagated to all Storages
-func (ss *SyncStorage) Delete(key storage.ObjectKey) error {
-	return ss.runAll(func(s storage.Storage) error {
-		return s.Delete(key)
-	})
+// Update is propagated to all Storages
+func (ss *SyncStorage) Update(obj runtime.Object) error {
+	if err := ss.Storage.Update(obj); err != nil {
+		return fmt.Errorf("SyncStorage: error in the read-write Storage: %w", err)
+	}
+
+	return ss.setAll(obj, ss.wStorages)
 }
 
-func (ss *SyncStorage) Close() error {
-	// Close all WatchStorages
-	for _, s := range ss.storages {
-		if watchStorage, ok := s.(watch.WatchStorage); ok {
-			_ = watchStorage.Close()
-		}
+// UpdateStatus is propagated to all Storages
+func (ss *SyncStorage) UpdateStatus(obj runtime.Object) error {
+	if err := ss.Storage.UpdateStatus(obj); err != nil {
+		return fmt.Errorf("SyncStorage: error in the read-write Storage: %w", err)
+	}
+
+	return ss.setAll(obj, ss.wStorages)
+}
+
+// Apply is propagated to all Storages. The write-only Storages
+// receive the merged Object from the read-write Storage.
+func (ss *SyncStorage) Apply(obj runtime.Object, fieldManager string, force bool) error {
+	if err := ss.Storage.Apply(obj, fieldManager, force); err != nil {
+		return fmt.Errorf("SyncStorage: error in the read-write Storage: %w", err)
+	}
+
+	return ss.setAll(obj, ss.wStorages)
+}
+
+// Patch is propagated to all Storages. The write-only Storages
+// receive the patched Object from the read-write Storage.
+func (ss *SyncStorage) Patch(key storage.ObjectKey, patchType types.PatchType, patch []byte) error {
+	if err := ss.Storage.Patch(key, patchType, patch); err != nil {
+		return fmt.Errorf("SyncStorage: error in the read-write Storage: %w", err)
+	}
+
+	obj, err := ss.Storage.Get(key)
+	if err != nil {
+		return fmt.Errorf("SyncStorage: error in the read-write Storage: %w", err)
+	}
+
+	return ss.setAll(obj, ss.wStorages)
+}
+
+// Delete is propagated to all Storages. The DeleteOptions are
+// only checked by the read-write Storage.
+func (ss *SyncStorage) Delete(key storage.ObjectKey, opts ...storage.DeleteOption) error {
+	if err := ss.Storage.Delete(key, opts...); err != nil {
+		return fmt.Errorf("SyncStorage: error in the read-write Storage: %w", err)
 	}
 
-	// Close the event streams if set
+	return ss.deleteAll(key, ss.wStorages)
+}
+
+func (ss *SyncStorage) SetUpdateStream(eventStream update.UpdateStream) {
+	ss.outboundStream = eventStream
+}
+
+func (ss *SyncStorage) Close() error {
+	// Close all Storages, this stops the EventStorages from sending updates
+	err := runAll(ss.storages(), func(s storage.Storage) error {
+		return s.Close()
+	})
+
+	// Close the inbound stream if set, and wait for the monitor goroutine
 	if ss.inboundStream != nil {
 		close(ss.inboundStream)
 	}
-	if ss.outboundStream != nil {
-		close(ss.outboundStream)
-	}
-	// Wait for the monitor goroutine
 	ss.monitor.Wait()
-	return nil
+	return err
 }
 
-func (ss *SyncStorage) GetUpdateStream() update.UpdateStream {
-	return ss.outboundStream
+// setAll writes a copy of the given Object to all given Storages, creating it if it doesn't exist.
+// The resourceVersion is cleared, as it only applies to the Storage the Object was read from.
+func (ss *SyncStorage) setAll(obj runtime.Object, storages []storage.Storage) error {
+	newCopy := func() runtime.Object {
+		objCopy := obj.DeepCopyObject().(runtime.Object)
+		objCopy.SetResourceVersion("")
+		return objCopy
+	}
+
+	return runAll(storages, func(s storage.Storage) error {
+		key, err := s.ObjectKeyFor(obj)
+		if err != nil {
+			return err
+		}
+
+		if !s.RawStorage().Exists(key) {
+			return s.Create(newCopy())
+		}
+		if err := s.Update(newCopy()); err != nil {
+			return err
+		}
+
+		// Update ignores the status of storages with a status subresource, replicate it separately then
+		if !s.StatusSubresource() {
+			return nil
+		}
+		if err := s.UpdateStatus(newCopy()); err != nil && !errors.Is(err, storage.ErrNoStatus) {
+			return err
+		}
+		return nil
+	})
 }
 
-// runAll runs the given function for all Storages in parallel and aggregates all errors
-func (ss *SyncStorage) runAll(f func(storage.Storage) error) (err error) {
-	type result struct {
-		int
-		error
-	}
+// deleteAll deletes the given key from all given Storages, ignoring the ones it doesn't exist in
+func (ss *SyncStorage) deleteAll(key storage.ObjectKey, storages []storage.Storage) error {
+	return runAll(storages, func(s storage.Storage) error {
+		if err := s.Delete(key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		return nil
+	})
+}
 
-	errC := make(chan result)
-	for i, s := range ss.storages {
+// runAll runs the given function for all Storages in parallel and aggregates all errors
+func runAll(storages []storage.Storage, f func(storage.Storage) error) error {
+	errs := make([]error, len(storages))
+	wg := &gosync.WaitGroup{}
+	for i, s := range storages {
+		wg.Add(1)
 		go func(i int, s storage.Storage) {
-			errC <- result{i, f(s)}
+			defer wg.Done()
+			if err := f(s); err != nil {
+				errs[i] = fmt.Errorf("SyncStorage: error in Storage %d: %w", i, err)
+			}
 		}(i, s) // NOTE: This requires i and s as arguments, otherwise they will be evaluated for one Storage only
 	}
+	wg.Wait()
 
-	for i := 0; i < len(ss.storages); i++ {
-		if result := <-errC; result.error != nil {
-			if err == nil {
-				err = fmt.Errorf("SyncStorage: Error in Storage %d: %v", result.int, result.error)
-			} else {
-				err = fmt.Errorf("%v\n%29s %d: %v", err, "and error in Storage", result.int, result.error)
-			}
+	return utilerrors.NewAggregate(errs)
+}
+
+// others returns all Storages except the given one
+func (ss *SyncStorage) others(s storage.Storage) []storage.Storage {
+	var result []storage.Storage
+	for _, other := range ss.storages() {
+		if other != s {
+			result = append(result, other)
 		}
 	}
-
-	return
+	return result
 }
 
 func (ss *SyncStorage) monitorFunc() {
@@ -138,51 +230,52 @@ func (ss *SyncStorage) monitorFunc() {
 	// TODO: Support detecting changes done when the GitOps daemon isn't running
 	// This is difficult to do though, as we have don't know which state is the latest
 	// For now, only update the state on write when the daemon is running
-	for {
-		upd, ok := <-ss.inboundStream
-		if ok {
-			log.Debugf("SyncStorage: Received update %v %t", upd, ok)
-
-			gvk := upd.PartialObject.GetObjectKind().GroupVersionKind()
-			uid := upd.PartialObject.GetUID()
-			key := storage.NewObjectKey(storage.NewKindKey(gvk), runtime.NewIdentifier(string(uid)))
-			log.Debugf("SyncStorage: Object has gvk=%q and uid=%q", gvk, uid)
-
-			switch upd.Event {
-			case update.ObjectEventModify, update.ObjectEventCreate:
-				// First load the Object using the Storage given in the update,
-				// then set it using the client constructed above
-
-				obj, err := upd.Storage.Get(key)
-				if err != nil {
-					log.Errorf("Failed to get Object with UID %q: %v", upd.PartialObject.GetUID(), err)
-					continue
-				}
-
-				if err = ss.Set(obj); err != nil {
-					log.Errorf("Failed to set Object with UID %q: %v", upd.PartialObject.GetUID(), err)
-					continue
-				}
-			case update.ObjectEventDelete:
-				// For deletion we use the generated "fake" APIType object
-				if err := ss.Delete(key); err != nil {
-					log.Errorf("Failed to delete Object with UID %q: %v", upd.PartialObject.GetUID(), err)
-					continue
-				}
+	for upd := range ss.inboundStream {
+		log.Debugf("SyncStorage: Received update %v", upd)
+
+		gvk := upd.PartialObject.GetObjectKind().GroupVersionKind()
+		others := ss.others(upd.Storage)
+
+		// Deleted Objects are sent as they were last seen, so
+		// the key can be computed the same way for all events
+		key, err := upd.Storage.ObjectKeyFor(upd.PartialObject)
+		if err != nil {
+			log.Errorf("SyncStorage: Failed to get the key of %s %q: %v", gvk.Kind, upd.PartialObject.GetName(), err)
+			continue
+		}
+
+		switch upd.Event {
+		case update.ObjectEventModify, update.ObjectEventCreate:
+			// First load the Object using the Storage given in the update,
+			// then write it to all the other Storages
+			obj, err := upd.Storage.Get(key)
+			if err != nil {
+				log.Errorf("SyncStorage: Failed to get %s: %v", key, err)
+				continue
 			}
 
-			// Send the update to the listeners unless the channel is full,
-			// in which case issue a warning. The channel can hold as many
-			// updates as updateBuffer specifies.
-			select {
-			case ss.outboundStream <- upd:
-				log.Debugf("SyncStorage: Sent update: %v", upd)
-			default:
-				log.Warn("SyncStorage: Failed to send update, channel full")
+			if err := ss.setAll(obj, others); err != nil {
+				log.Errorf("SyncStorage: Failed to set %s: %v", key, err)
+				continue
 			}
-		} else {
-			return
+		case update.ObjectEventDelete:
+			if err := ss.deleteAll(key, others); err != nil {
+				log.Errorf("SyncStorage: Failed to delete %s: %v", key, err)
+				continue
+			}
+		}
+
+		// Send the update to the listeners unless the channel is full,
+		// in which case issue a warning. The channel can hold as many
+		// updates as its buffer size specifies.
+		if ss.outboundStream == nil {
+			continue
+		}
+		select {
+		case ss.outboundStream <- update.Update{Event: upd.Event, PartialObject: upd.PartialObject, Storage: ss}:
+			log.Debugf("SyncStorage: Sent update: %v", upd)
+		default:
+			log.Warn("SyncStorage: Failed to send update, channel full")
 		}
 	}
 }
-*/
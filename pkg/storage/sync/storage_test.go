@@ -0,0 +1,217 @@
+package sync
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+	"github.com/save-abandoned-projects/libgitops/pkg/storage"
+	"github.com/save-abandoned-projects/libgitops/pkg/storage/watch/update"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var carKind = storage.NewKindKey(v1alpha1.SchemeGroupVersion.WithKind("Car"))
+
+func newTestCar(name, brand string) *v1alpha1.Car {
+	car := &v1alpha1.Car{}
+	car.SetGroupVersionKind(carKind.GetGVK())
+	car.Name = name
+	car.Namespace = "default"
+	car.Spec.Brand = brand
+	return car
+}
+
+func newTestStorage() storage.Storage {
+	return storage.NewGenericStorage(
+		storage.NewMemoryRawStorage(serializer.ContentTypeJSON),
+		scheme.Serializer,
+		[]runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+	)
+}
+
+// eventStorage is an update.EventStorage, which sends the updates given to send
+type eventStorage struct {
+	storage.Storage
+	events update.UpdateStream
+}
+
+func (s *eventStorage) SetUpdateStream(events update.UpdateStream) {
+	s.events = events
+}
+
+func (s *eventStorage) send(event update.ObjectEvent, obj runtime.Object) {
+	partObj := &runtime.PartialObjectImpl{}
+	partObj.SetGroupVersionKind(carKind.GetGVK())
+	partObj.SetName(obj.GetName())
+	partObj.SetNamespace(obj.GetNamespace())
+	s.events <- update.Update{Event: event, PartialObject: partObj, Storage: s}
+}
+
+// failingStorage fails all writes
+type failingStorage struct {
+	storage.Storage
+}
+
+var errTest = errors.New("test")
+
+func (failingStorage) Create(runtime.Object) error              { return errTest }
+func (failingStorage) Update(runtime.Object) error              { return errTest }
+func (failingStorage) UpdateStatus(runtime.Object) error        { return errTest }
+func (failingStorage) Apply(runtime.Object, string, bool) error { return errTest }
+
+// countingStorage counts the calls to Update and UpdateStatus
+type countingStorage struct {
+	storage.Storage
+	updates, statusUpdates int
+}
+
+func (s *countingStorage) Update(obj runtime.Object) error {
+	s.updates++
+	return s.Storage.Update(obj)
+}
+
+func (s *countingStorage) UpdateStatus(obj runtime.Object) error {
+	s.statusUpdates++
+	return s.Storage.UpdateStatus(obj)
+}
+
+func getBrand(t *testing.T, s storage.Storage, key storage.ObjectKey) string {
+	obj, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj.(*v1alpha1.Car).Spec.Brand
+}
+
+func TestSyncStorage(t *testing.T) {
+	rw, w1, w2 := newTestStorage(), newTestStorage(), newTestStorage()
+	ss := NewSyncStorage(rw, w1, w2)
+	key := storage.NewObjectKey(carKind, runtime.NewIdentifier("default/foo"))
+
+	car := newTestCar("foo", "Volvo")
+	if err := ss.Create(car); err != nil {
+		t.Fatal(err)
+	}
+
+	// The write-only Storages don't share the resourceVersion of the read-write one
+	car.Spec.Brand = "Saab"
+	if err := ss.Update(car); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range []storage.Storage{rw, w1, w2} {
+		if brand := getBrand(t, s, key); brand != "Saab" {
+			t.Errorf("expected brand Saab in Storage %d, got %q", i, brand)
+		}
+	}
+
+	// The resourceVersion is only checked by the read-write Storage
+	car.SetResourceVersion("outdated")
+	if err := ss.Update(car); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+
+	// Patches are propagated even if the Object is missing in a write-only Storage
+	if err := w2.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.Patch(key, types.StrategicMergePatchType, []byte(`{"spec":{"brand":"Acura"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range []storage.Storage{rw, w1, w2} {
+		if brand := getBrand(t, s, key); brand != "Acura" {
+			t.Errorf("expected brand Acura in Storage %d, got %q", i, brand)
+		}
+	}
+
+	if err := ss.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range []storage.Storage{rw, w1, w2} {
+		if s.RawStorage().Exists(key) {
+			t.Errorf("expected the Object to be deleted from Storage %d", i)
+		}
+	}
+}
+
+func TestSyncStorage_Status(t *testing.T) {
+	w := &countingStorage{Storage: newTestStorage()}
+	wStatus := &countingStorage{Storage: storage.NewGenericStorage(
+		storage.NewMemoryRawStorage(serializer.ContentTypeJSON),
+		scheme.Serializer,
+		[]runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+		storage.WithStatusSubresource(true),
+	)}
+	ss := NewSyncStorage(newTestStorage(), w, wStatus)
+	key := storage.NewObjectKey(carKind, runtime.NewIdentifier("default/foo"))
+
+	car := newTestCar("foo", "Volvo")
+	if err := ss.Create(car); err != nil {
+		t.Fatal(err)
+	}
+	car.Status.Speed = 42
+	if err := ss.Update(car); err != nil {
+		t.Fatal(err)
+	}
+
+	// The status is only written separately if Update ignored it
+	if w.updates != 1 || w.statusUpdates != 0 {
+		t.Errorf("expected 1 update and no status update, got %d and %d", w.updates, w.statusUpdates)
+	}
+	if wStatus.updates != 1 || wStatus.statusUpdates != 1 {
+		t.Errorf("expected 1 update and 1 status update, got %d and %d", wStatus.updates, wStatus.statusUpdates)
+	}
+	for i, s := range []storage.Storage{w, wStatus} {
+		obj, err := s.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if speed := obj.(*v1alpha1.Car).Status.Speed; speed != 42 {
+			t.Errorf("expected speed 42 in Storage %d, got %v", i, speed)
+		}
+	}
+}
+
+func TestSyncStorage_Errors(t *testing.T) {
+	ss := NewSyncStorage(newTestStorage(), newTestStorage(), failingStorage{newTestStorage()})
+
+	err := ss.Create(newTestCar("foo", "Volvo"))
+	if !errors.Is(err, errTest) {
+		t.Errorf("expected the error of the failing Storage, got %v", err)
+	}
+}
+
+func TestSyncStorage_Events(t *testing.T) {
+	rw := &eventStorage{Storage: newTestStorage()}
+	w := newTestStorage()
+	ss := NewSyncStorage(rw, w)
+	events := make(update.UpdateStream, 1)
+	ss.SetUpdateStream(events)
+	key := storage.NewObjectKey(carKind, runtime.NewIdentifier("default/foo"))
+
+	// Simulate an external change to the read-write Storage
+	car := newTestCar("foo", "Volvo")
+	if err := rw.Storage.Create(car); err != nil {
+		t.Fatal(err)
+	}
+	rw.send(update.ObjectEventCreate, car)
+
+	select {
+	case upd := <-events:
+		if upd.Event != update.ObjectEventCreate || upd.Storage != ss {
+			t.Errorf("unexpected update %v", upd)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the update")
+	}
+	if brand := getBrand(t, w, key); brand != "Volvo" {
+		t.Errorf("expected the Object to be mirrored, got brand %q", brand)
+	}
+
+	if err := ss.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,7 +3,9 @@ package transaction
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 	"strings"
+	gosync "sync"
 
 	"github.com/save-abandoned-projects/libgitops/pkg/gitdir"
 	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
@@ -12,23 +14,44 @@ import (
 	"github.com/save-abandoned-projects/libgitops/pkg/util"
 	"github.com/save-abandoned-projects/libgitops/pkg/util/watcher"
 	"github.com/sirupsen/logrus"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
 )
 
 var excludeDirs = []string{".git"}
 
-func NewGitStorage(gitDir gitdir.GitDirectory, prProvider PullRequestProvider, ser serializer.Serializer) (TransactionStorage, error) {
+// NewGitStorage creates a TransactionStorage for the given Git directory. The options configure the
+// underlying GenericStorage, e.g. storage.WithAdmissionHooks adds admission hooks which are called for
+// all writes done in transactions, and storage.WithUnstructured makes the objects of kinds unknown to
+// the scheme available too, so that the whole repository can be managed.
+func NewGitStorage(gitDir gitdir.GitDirectory, prProvider PullRequestProvider, ser serializer.Serializer, optFns ...storage.GenericStorageOptionsFunc) (TransactionStorage, error) {
 	// Make sure the repo is cloned. If this func has already been called, it will be a no-op.
 	if err := gitDir.StartCheckoutLoop(); err != nil {
 		return nil, err
 	}
 
-	raw := storage.NewGenericMappedRawStorage(gitDir.Dir())
-	s := storage.NewGenericStorage(raw, ser, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+	// Use the git blob hash as checksum, so it is stable across clones. New objects are
+	// written to <kind>/<namespace>/<name>.yaml, and committed together with the changes.
+	newFiles := &newFilesPathStrategy{PathStrategy: storage.KindNamespaceNamePathStrategy}
+	raw := storage.NewGenericMappedRawStorage(
+		gitDir.Dir(),
+		storage.WithChecksum(storage.GitBlobChecksum),
+		storage.WithPathStrategy(newFiles),
+	)
+	opts := storage.MakeGenericStorageOptions(optFns...)
+	s := storage.NewGenericStorageWithOptions(raw, ser, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}, opts)
+
+	// Only map the frames of kinds unknown to the scheme if the storage can read them
+	scheme := ser.Scheme()
+	if *opts.Unstructured {
+		scheme = nil
+	}
 
 	gitStorage := &GitStorage{
 		ReadStorage: s,
 		s:           s,
 		raw:         raw,
+		newFiles:    newFiles,
+		scheme:      scheme,
 		gitDir:      gitDir,
 		prProvider:  prProvider,
 	}
@@ -46,6 +69,8 @@ type GitStorage struct {
 
 	s          storage.Storage
 	raw        storage.MappedRawStorage
+	newFiles   *newFilesPathStrategy
+	scheme     *kruntime.Scheme
 	gitDir     gitdir.GitDirectory
 	prProvider PullRequestProvider
 }
@@ -64,7 +89,7 @@ func (s *GitStorage) syncLoop() {
 }
 
 func (s *GitStorage) sync() error {
-	mappings, err := computeMappings(s.gitDir.Dir(), s.s)
+	mappings, err := computeMappings(s.gitDir.Dir(), s.s, s.scheme)
 	if err != nil {
 		return err
 	}
@@ -98,7 +123,8 @@ func (s *GitStorage) Transaction(ctx context.Context, streamName string, fn Tran
 	if err := s.gitDir.CheckoutNewBranch(streamName); err != nil {
 		return err
 	}
-	// Invoke the transaction
+	// Invoke the transaction, recording the files it creates
+	s.newFiles.reset()
 	result, err := fn(ctx, s.s)
 	if err != nil {
 		return err
@@ -107,8 +133,14 @@ func (s *GitStorage) Transaction(ctx context.Context, streamName string, fn Tran
 	if err := result.Validate(); err != nil {
 		return fmt.Errorf("transaction result is not valid: %w", err)
 	}
-	// Perform the commit
-	if err := s.gitDir.Commit(ctx, result.GetAuthorName(), result.GetAuthorEmail(), result.GetMessage()); err != nil {
+	// Perform the commit, adding only the new files which still hold objects
+	var newFiles []string
+	for _, p := range s.newFiles.paths() {
+		if len(s.raw.GetKeys(filepath.Join(s.gitDir.Dir(), filepath.FromSlash(p)))) != 0 {
+			newFiles = append(newFiles, p)
+		}
+	}
+	if err := s.gitDir.Commit(ctx, result.GetAuthorName(), result.GetAuthorEmail(), result.GetMessage(), newFiles...); err != nil {
 		return err
 	}
 	// Return if no PR should be made
@@ -129,7 +161,9 @@ func (s *GitStorage) Transaction(ctx context.Context, streamName string, fn Tran
 	})
 }
 
-func computeMappings(dir string, s storage.Storage) (map[storage.ObjectKey]string, error) {
+// computeMappings maps the objects in the frames of all files in dir. If scheme is nil, the objects
+// of all kinds are mapped, otherwise only the kinds known to the scheme.
+func computeMappings(dir string, s storage.Storage, scheme *kruntime.Scheme) (map[storage.ObjectKey]storage.FileFrame, error) {
 	validExts := make([]string, 0, len(storage.ContentTypes))
 	for ext := range storage.ContentTypes {
 		validExts = append(validExts, ext)
@@ -142,20 +176,64 @@ func computeMappings(dir string, s storage.Storage) (map[storage.ObjectKey]strin
 
 	// TODO: Compute the difference between the earlier state, and implement EventStorage so the user
 	// can automatically subscribe to changes of objects between versions.
-	m := map[storage.ObjectKey]string{}
+	m := map[storage.ObjectKey]storage.FileFrame{}
 	for _, file := range files {
-		partObjs, err := storage.DecodePartialObjects(serializer.FromFile(file), s.Serializer().Scheme(), false, nil)
+		frames, err := storage.ReadFileFrames(file)
 		if err != nil {
-			logrus.Errorf("couldn't decode %q into a partial object: %v", file, err)
+			logrus.Errorf("couldn't read the frames of %q: %v", file, err)
 			continue
 		}
-		key, err := s.ObjectKeyFor(partObjs[0])
-		if err != nil {
-			logrus.Errorf("couldn't get objectkey for partial object: %v", err)
-			continue
+
+		// Map every frame holding a known object, the index is kept for the other frames too
+		for i, frame := range frames {
+			partObjs, err := storage.DecodePartialObjects(serializer.FromBytes(frame), scheme, false, nil)
+			if err != nil {
+				logrus.Errorf("couldn't decode frame %d of %q into a partial object: %v", i, file, err)
+				continue
+			}
+			key, err := s.ObjectKeyFor(partObjs[0])
+			if err != nil {
+				logrus.Errorf("couldn't get objectkey for partial object: %v", err)
+				continue
+			}
+			f := storage.FileFrame{Path: file, Frame: i}
+			logrus.Debugf("Adding mapping between %s and %q", key, f)
+			m[key] = f
 		}
-		logrus.Debugf("Adding mapping between %s and %q", key, file)
-		m[key] = file
 	}
 	return m, nil
 }
+
+// newFilesPathStrategy records the paths returned by the embedded PathStrategy, i.e. the
+// files created for new objects, so that only these are added to the commit.
+type newFilesPathStrategy struct {
+	storage.PathStrategy
+
+	mux      gosync.Mutex
+	newPaths []string
+}
+
+func (s *newFilesPathStrategy) Path(key storage.ObjectKey) (string, error) {
+	p, err := s.PathStrategy.Path(key)
+	if err != nil {
+		return "", err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.newPaths = append(s.newPaths, p)
+	return p, nil
+}
+
+// paths returns the paths recorded since the last reset
+func (s *newFilesPathStrategy) paths() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]string(nil), s.newPaths...)
+}
+
+func (s *newFilesPathStrategy) reset() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.newPaths = nil
+}
@@ -0,0 +1,40 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/save-abandoned-projects/libgitops/pkg/storage"
+)
+
+// MigrateObjects runs storage.MigrateObjects in a single transaction with the given stream name, so that
+// all migrated Objects are committed together using the given CommitResult. If it is a PullRequestResult,
+// the migration lands as one pull request. If any Object fails to migrate, the transaction is aborted and
+// the error is returned. If all Objects already are stored in their preferred versions, the transaction is
+// aborted and nil is returned. The per-object results are returned in all cases.
+func MigrateObjects(ctx context.Context, s TransactionStorage, streamName string, result CommitResult) ([]storage.MigrationResult, error) {
+	var results []storage.MigrationResult
+	err := s.Transaction(ctx, streamName, func(ctx context.Context, s storage.Storage) (CommitResult, error) {
+		var err error
+		if results, err = storage.MigrateObjects(s); err != nil {
+			return nil, err
+		}
+
+		migrated := false
+		for _, r := range results {
+			if r.Err != nil {
+				return nil, fmt.Errorf("couldn't migrate %s: %w", r.Key, r.Err)
+			}
+			migrated = migrated || r.Migrated
+		}
+		if !migrated {
+			return nil, ErrAbortTransaction
+		}
+		return result, nil
+	})
+	if errors.Is(err, ErrAbortTransaction) {
+		err = nil
+	}
+	return results, err
+}
@@ -1,7 +1,8 @@
 package watch
 
 import (
-	"io/ioutil"
+	"crypto/sha256"
+	gosync "sync"
 
 	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
 	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
@@ -16,13 +17,15 @@ import (
 
 // NewManifestStorage returns a pre-configured GenericWatchStorage backed by a storage.GenericStorage,
 // and a GenericMappedRawStorage for the given manifestDir and Serializer. This should be sufficient
-// for most users that want to watch changes in a directory with manifests.
-func NewManifestStorage(manifestDir string, ser serializer.Serializer) (update.EventStorage, error) {
+// for most users that want to watch changes in a directory with manifests. New objects are written
+// to <kind>/<namespace>/<name>.yaml in the manifestDir. The options configure the GenericStorage.
+func NewManifestStorage(manifestDir string, ser serializer.Serializer, optFns ...storage.GenericStorageOptionsFunc) (update.EventStorage, error) {
 	return NewGenericWatchStorage(
 		storage.NewGenericStorage(
-			storage.NewGenericMappedRawStorage(manifestDir),
+			storage.NewGenericMappedRawStorage(manifestDir, storage.WithPathStrategy(storage.KindNamespaceNamePathStrategy)),
 			ser,
 			[]runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+			optFns...,
 		),
 	)
 }
@@ -31,11 +34,12 @@ func NewManifestStorage(manifestDir string, ser serializer.Serializer) (update.E
 // for watching changes in the directory managed by the embedded Storage's RawStorage.
 // If the RawStorage is a MappedRawStorage instance, it's mappings will automatically
 // be updated by the WatchStorage. Update events are sent to the given event stream.
-// Note: This WatchStorage only works for one-frame files (i.e. only one YAML document
-// per file is supported).
+// Files can hold multiple objects (e.g. YAML documents separated by "---"), events are
+// sent for every object created, modified or deleted in a file.
 func NewGenericWatchStorage(s storage.Storage) (update.EventStorage, error) {
 	ws := &GenericWatchStorage{
 		Storage: s,
+		known:   make(map[string]fileObject),
 	}
 
 	var err error
@@ -51,8 +55,10 @@ func NewGenericWatchStorage(s storage.Storage) (update.EventStorage, error) {
 	return ws, nil
 }
 
-// EventDeleteObjectName is used as the name of an object sent to the
-// GenericWatchStorage's event stream when the the object has been deleted
+// EventDeleteObjectName was used as the name of an object sent to the
+// GenericWatchStorage's event stream when the the object has been deleted.
+//
+// Deprecated: Delete events carry the name and namespace of the deleted object.
 const EventDeleteObjectName = "<deleted>"
 
 // GenericWatchStorage implements the WatchStorage interface
@@ -61,6 +67,11 @@ type GenericWatchStorage struct {
 	watcher *watcher.FileWatcher
 	events  update.UpdateStream
 	monitor *sync.Monitor
+	// known holds the last read state of the objects, in order to only send events for the objects
+	// that changed in a multi-object file, and to send the deleted objects in delete events
+	known map[string]fileObject
+	// knownMux guards known, which is also used by Delete
+	knownMux gosync.Mutex
 }
 
 var _ update.EventStorage = &GenericWatchStorage{}
@@ -77,16 +88,43 @@ func (s *GenericWatchStorage) Update(obj runtime.Object) error {
 	return s.Storage.Update(obj)
 }
 
+// Suspend modify events during UpdateStatus
+func (s *GenericWatchStorage) UpdateStatus(obj runtime.Object) error {
+	s.watcher.Suspend(watcher.FileEventModify)
+	return s.Storage.UpdateStatus(obj)
+}
+
+// Suspend modify events during Apply
+func (s *GenericWatchStorage) Apply(obj runtime.Object, fieldManager string, force bool) error {
+	s.watcher.Suspend(watcher.FileEventModify)
+	return s.Storage.Apply(obj, fieldManager, force)
+}
+
 // Suspend modify events during Patch
-func (s *GenericWatchStorage) Patch(key storage.ObjectKey, patch []byte) error {
+func (s *GenericWatchStorage) Patch(key storage.ObjectKey, patchType types.PatchType, patch []byte) error {
 	s.watcher.Suspend(watcher.FileEventModify)
-	return s.Storage.Patch(key, patch)
+	return s.Storage.Patch(key, patchType, patch)
 }
 
-// Suspend delete events during Delete
-func (s *GenericWatchStorage) Delete(key storage.ObjectKey) error {
-	s.watcher.Suspend(watcher.FileEventDelete)
-	return s.Storage.Delete(key)
+// Suspend the event of the file holding the object during Delete. Files holding other
+// objects too are rewritten, which is a modify event, the others are deleted.
+func (s *GenericWatchStorage) Delete(key storage.ObjectKey, opts ...storage.DeleteOption) error {
+	event := watcher.FileEventDelete
+	s.knownMux.Lock()
+	if known, ok := s.known[key.String()]; ok && len(keysForPath(s.RawStorage(), known.file.Path)) > 1 {
+		event = watcher.FileEventModify
+	}
+	s.knownMux.Unlock()
+
+	s.watcher.Suspend(event)
+	if err := s.Storage.Delete(key, opts...); err != nil {
+		return err
+	}
+
+	s.knownMux.Lock()
+	delete(s.known, key.String())
+	s.knownMux.Unlock()
+	return nil
 }
 
 func (s *GenericWatchStorage) SetUpdateStream(eventStream update.UpdateStream) {
@@ -102,108 +140,180 @@ func (s *GenericWatchStorage) Close() error {
 func (s *GenericWatchStorage) monitorFunc(raw storage.RawStorage, files []string) {
 	log.Debug("GenericWatchStorage: Monitoring thread started")
 	defer log.Debug("GenericWatchStorage: Monitoring thread stopped")
-	var content []byte
 
-	// Send a MODIFY event for all files (and fill the mappings
+	// Send a MODIFY event for all objects (and fill the mappings
 	// of the MappedRawStorage) before starting to monitor changes
 	for _, file := range files {
-		content, err := ioutil.ReadFile(file)
+		objs, err := s.readFile(file)
 		if err != nil {
 			log.Warnf("Ignoring %q: %v", file, err)
 			continue
 		}
 
-		obj, err := runtime.NewPartialObject(content)
-		if err != nil {
-			log.Warnf("Ignoring %q: %v", file, err)
-			continue
+		for _, obj := range objs {
+			// Add a mapping between this object and its frame
+			s.addMapping(raw, obj.key, obj.file)
+			s.knownMux.Lock()
+			s.known[obj.key.String()] = obj
+			s.knownMux.Unlock()
+			// Send the event to the events channel
+			s.sendEvent(update.ObjectEventModify, obj.partObj)
 		}
-
-		// Add a mapping between this object and path
-		s.addMapping(raw, obj, file)
-		// Send the event to the events channel
-		s.sendEvent(update.ObjectEventModify, obj)
 	}
 
 	for {
-		if event, ok := <-s.watcher.GetFileUpdateStream(); ok {
-			var partObj runtime.PartialObject
-			var err error
-
-			var objectEvent update.ObjectEvent
-			switch event.Event {
-			case watcher.FileEventModify:
-				objectEvent = update.ObjectEventModify
-			case watcher.FileEventDelete:
-				objectEvent = update.ObjectEventDelete
-			}
+		event, ok := <-s.watcher.GetFileUpdateStream()
+		if !ok {
+			return
+		}
 
-			log.Tracef("GenericWatchStorage: Processing event: %s", event.Event)
-			if event.Event == watcher.FileEventDelete {
-				key, err := raw.GetKey(event.Path)
-				if err != nil {
-					log.Warnf("Failed to retrieve data for %q: %v", event.Path, err)
-					continue
-				}
-
-				// This creates a "fake" Object from the key to be used for
-				// deletion, as the original has already been removed from disk
-				apiVersion, kind := key.GetGVK().ToAPIVersionAndKind()
-				partObj = &runtime.PartialObjectImpl{
-					TypeMeta: metav1.TypeMeta{
-						APIVersion: apiVersion,
-						Kind:       kind,
-					},
-					ObjectMeta: metav1.ObjectMeta{
-						Name: EventDeleteObjectName,
-						// TODO: This doesn't take into account where e.g. the identifier is "{namespace}/{name}"
-						UID: types.UID(key.GetIdentifier()),
-					},
-				}
-				// remove the mapping for this key as it's now deleted
-				s.removeMapping(raw, key)
-			} else {
-				content, err = ioutil.ReadFile(event.Path)
-				if err != nil {
-					log.Warnf("Ignoring %q: %v", event.Path, err)
-					continue
-				}
-
-				if partObj, err = runtime.NewPartialObject(content); err != nil {
-					log.Warnf("Ignoring %q: %v", event.Path, err)
-					continue
-				}
-
-				if event.Event == watcher.FileEventMove {
-					// Update the mappings for the moved file (AddMapping overwrites)
-					s.addMapping(raw, partObj, event.Path)
-
-					// Internal move events are a no-op
-					continue
-				}
-
-				// This is based on the key's existence instead of watcher.EventCreate,
-				// as Objects can get updated (via watcher.FileEventModify) to be conformant
-				if _, err = raw.GetKey(event.Path); err != nil {
-					// Add a mapping between this object and path
-					s.addMapping(raw, partObj, event.Path)
-
-					// This is what actually determines if an Object is created,
-					// so update the event to update.ObjectEventCreate here
-					objectEvent = update.ObjectEventCreate
-				}
+		log.Tracef("GenericWatchStorage: Processing event: %s", event.Event)
+		switch event.Event {
+		case watcher.FileEventDelete:
+			// All objects in the file were deleted
+			for _, key := range keysForPath(raw, event.Path) {
+				s.deleteObject(raw, key)
+			}
+		case watcher.FileEventMove:
+			objs, err := s.readFile(event.Path)
+			if err != nil {
+				log.Warnf("Ignoring %q: %v", event.Path, err)
+				continue
 			}
 
-			// Send the objectEvent to the events channel
-			if objectEvent != update.ObjectEventNone {
-				s.sendEvent(objectEvent, partObj)
+			// Update the mappings for the moved file (AddMapping overwrites)
+			// Internal move events are a no-op otherwise
+			for _, obj := range objs {
+				s.addMapping(raw, obj.key, obj.file)
 			}
-		} else {
-			return
+		case watcher.FileEventModify:
+			s.modifyFile(raw, event.Path)
 		}
 	}
 }
 
+// modifyFile sends events for the objects which were created, modified or
+// deleted in the given file, and updates the mappings accordingly
+func (s *GenericWatchStorage) modifyFile(raw storage.RawStorage, path string) {
+	objs, err := s.readFile(path)
+	if err != nil {
+		log.Warnf("Ignoring %q: %v", path, err)
+		return
+	}
+
+	// The keys of the objects which were in the file before this change
+	previous := map[string]storage.ObjectKey{}
+	for _, key := range keysForPath(raw, path) {
+		previous[key.String()] = key
+	}
+
+	for _, obj := range objs {
+		// This is based on the key's existence instead of watcher.EventCreate,
+		// as Objects can get updated (via watcher.FileEventModify) to be conformant
+		objectEvent := update.ObjectEventModify
+		if _, ok := previous[obj.key.String()]; !ok {
+			objectEvent = update.ObjectEventCreate
+		}
+		delete(previous, obj.key.String())
+
+		// Add a mapping between this object and its frame, the frame index may have changed
+		s.addMapping(raw, obj.key, obj.file)
+
+		// Skip the objects in the frames that didn't change
+		s.knownMux.Lock()
+		known, ok := s.known[obj.key.String()]
+		unchanged := ok && known.sum == obj.sum && objectEvent == update.ObjectEventModify
+		s.known[obj.key.String()] = obj
+		s.knownMux.Unlock()
+		if unchanged {
+			continue
+		}
+
+		// Send the objectEvent to the events channel
+		s.sendEvent(objectEvent, obj.partObj)
+	}
+
+	// The objects which are no longer in the file were deleted
+	for _, key := range previous {
+		s.deleteObject(raw, key)
+	}
+}
+
+// deleteObject removes the mapping of the given key, and sends a delete event for it
+func (s *GenericWatchStorage) deleteObject(raw storage.RawStorage, key storage.ObjectKey) {
+	// Send the object as it was last read, the original has already been removed from disk
+	s.knownMux.Lock()
+	known, ok := s.known[key.String()]
+	delete(s.known, key.String())
+	s.knownMux.Unlock()
+
+	partObj := known.partObj
+	if !ok {
+		// This creates a "fake" Object from the key to be used for deletion. The namespace
+		// is left empty for cluster-scoped objects, identified only by their name.
+		apiVersion, kind := key.GetGVK().ToAPIVersionAndKind()
+		namespace, name := runtime.SplitIdentifier(key.GetIdentifier())
+		partObj = &runtime.PartialObjectImpl{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: apiVersion,
+				Kind:       kind,
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      name,
+				UID:       types.UID(key.GetIdentifier()),
+			},
+		}
+	}
+
+	// remove the mapping for this key as it's now deleted
+	s.removeMapping(raw, key)
+	s.sendEvent(update.ObjectEventDelete, partObj)
+}
+
+// fileObject is an object read from one frame of a file
+type fileObject struct {
+	key     storage.ObjectKey
+	file    storage.FileFrame
+	partObj runtime.PartialObject
+	sum     [sha256.Size]byte
+}
+
+// readFile decodes the objects in all frames of the given file. Frames
+// which can't be decoded are skipped, but keep their index.
+func (s *GenericWatchStorage) readFile(path string) ([]fileObject, error) {
+	frames, err := storage.ReadFileFrames(path)
+	if err != nil {
+		return nil, err
+	}
+
+	objs := make([]fileObject, 0, len(frames))
+	for i, frame := range frames {
+		partObj, err := runtime.NewPartialObject(frame)
+		if err != nil {
+			log.Warnf("Ignoring frame %d of %q: %v", i, path, err)
+			continue
+		}
+
+		// Let the embedded storage decide using its identifiers how to identify the object
+		key, err := s.Storage.ObjectKeyFor(partObj)
+		if err != nil {
+			log.Warnf("Ignoring frame %d of %q: couldn't get object key for: gvk=%s, uid=%s, name=%s", i, path,
+				partObj.GetObjectKind().GroupVersionKind(), partObj.GetUID(), partObj.GetName())
+			continue
+		}
+
+		objs = append(objs, fileObject{
+			key:     key,
+			file:    storage.FileFrame{Path: path, Frame: i},
+			partObj: partObj,
+			sum:     sha256.Sum256(frame),
+		})
+	}
+
+	return objs, nil
+}
+
 func (s *GenericWatchStorage) sendEvent(event update.ObjectEvent, partObj runtime.PartialObject) {
 	if s.events != nil {
 		log.Tracef("GenericWatchStorage: Sending event: %v", event)
@@ -215,21 +325,15 @@ func (s *GenericWatchStorage) sendEvent(event update.ObjectEvent, partObj runtim
 	}
 }
 
-// addMapping registers a mapping between the given object and the specified path, if raw is a
-// MappedRawStorage. If a given mapping already exists between this object and some path, it
-// will be overridden with the specified new path
-func (s *GenericWatchStorage) addMapping(raw storage.RawStorage, obj runtime.Object, file string) {
+// addMapping registers a mapping between the given key and the specified file frame, if raw is a
+// MappedRawStorage. If a given mapping already exists between this key and some frame, it
+// will be overridden with the specified new frame
+func (s *GenericWatchStorage) addMapping(raw storage.RawStorage, key storage.ObjectKey, file storage.FileFrame) {
 	mapped, ok := raw.(storage.MappedRawStorage)
 	if !ok {
 		return
 	}
 
-	// Let the embedded storage decide using its identifiers how to
-	key, err := s.Storage.ObjectKeyFor(obj)
-	if err != nil {
-		log.Errorf("couldn't get object key for: gvk=%s, uid=%s, name=%s", obj.GetObjectKind().GroupVersionKind(), obj.GetUID(), obj.GetName())
-	}
-
 	mapped.AddMapping(key, file)
 }
 
@@ -242,3 +346,17 @@ func (s *GenericWatchStorage) removeMapping(raw storage.RawStorage, key storage.
 
 	mapped.RemoveMapping(key)
 }
+
+// keysForPath returns the keys of the objects stored in the given file
+func keysForPath(raw storage.RawStorage, path string) []storage.ObjectKey {
+	if mapped, ok := raw.(storage.MappedRawStorage); ok {
+		return mapped.GetKeys(path)
+	}
+
+	key, err := raw.GetKey(path)
+	if err != nil {
+		log.Warnf("Failed to retrieve data for %q: %v", path, err)
+		return nil
+	}
+	return []storage.ObjectKey{key}
+}
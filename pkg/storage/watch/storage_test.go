@@ -0,0 +1,161 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
+	"github.com/save-abandoned-projects/libgitops/pkg/storage"
+	"github.com/save-abandoned-projects/libgitops/pkg/storage/watch/update"
+	"github.com/save-abandoned-projects/libgitops/pkg/util/sync"
+	"github.com/save-abandoned-projects/libgitops/pkg/util/watcher"
+)
+
+var carGVK = v1alpha1.SchemeGroupVersion.WithKind("Car")
+
+func carYAML(name, brand string) string {
+	return `apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: ` + name + `
+  namespace: default
+spec:
+  brand: ` + brand + "\n"
+}
+
+func TestGenericWatchStorage_MultiFrame(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cars.yaml")
+	raw := storage.NewGenericMappedRawStorage(filepath.Dir(file))
+	events := make(update.UpdateStream, 10)
+	s := &GenericWatchStorage{
+		Storage: storage.NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}),
+		events:  events,
+		known:   make(map[string]fileObject),
+	}
+
+	// modify writes the given frames to the file, and returns the events sent for the change
+	modify := func(frames ...string) []string {
+		if err := os.WriteFile(file, []byte(strings.Join(frames, "---\n")), 0644); err != nil {
+			t.Fatal(err)
+		}
+		s.modifyFile(raw, file)
+
+		var got []string
+		for len(events) > 0 {
+			upd := <-events
+			got = append(got, upd.Event.String()+" "+upd.PartialObject.GetName()+string(upd.PartialObject.GetUID()))
+		}
+		return got
+	}
+
+	tests := []struct {
+		name   string
+		frames []string
+		want   []string
+	}{
+		{
+			name:   "create",
+			frames: []string{carYAML("a", "Volvo"), carYAML("b", "Volvo")},
+			want:   []string{"CREATE a", "CREATE b"},
+		},
+		{
+			name:   "modify one",
+			frames: []string{carYAML("a", "Volvo"), carYAML("b", "Saab")},
+			want:   []string{"MODIFY b"},
+		},
+		{
+			name:   "delete one",
+			frames: []string{carYAML("b", "Saab")},
+			want:   []string{"DELETE a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := modify(tt.frames...)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("expected events %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	// The mappings follow the frames
+	keys := raw.GetKeys(file)
+	if len(keys) != 1 || keys[0].GetIdentifier() != "default/b" {
+		t.Fatalf("expected only b to be mapped, got %v", keys)
+	}
+	obj, err := s.Get(keys[0])
+	if err != nil || obj.GetName() != "b" {
+		t.Errorf("Get() = %v, %v, want b", obj, err)
+	}
+}
+
+func TestGenericWatchStorage_DeleteFrame(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "cars.yaml"), []byte(carYAML("a", "Volvo")+"---\n"+carYAML("b", "Saab")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(dir, "other.yaml")
+	if err := os.WriteFile(other, []byte(carYAML("c", "Tesla")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := storage.NewGenericMappedRawStorage(dir)
+	events := make(update.UpdateStream, 10)
+	s := &GenericWatchStorage{
+		Storage: storage.NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}),
+		events:  events,
+		known:   make(map[string]fileObject),
+	}
+	var files []string
+	var err error
+	if s.watcher, files, err = watcher.NewFileWatcher(dir); err != nil {
+		t.Fatal(err)
+	}
+	s.monitor = sync.RunMonitor(func() {
+		s.monitorFunc(raw, files)
+	})
+	defer s.Close()
+
+	// next returns the next event, or an empty string if none is sent before the timeout
+	next := func(timeout time.Duration) string {
+		select {
+		case upd := <-events:
+			return upd.Event.String() + " " + upd.PartialObject.GetName()
+		case <-time.After(timeout):
+			return ""
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if got := next(5 * time.Second); !strings.HasPrefix(got, "MODIFY") {
+			t.Fatalf("expected an initial MODIFY event, got %q", got)
+		}
+	}
+
+	// Deleting a rewrites cars.yaml, which must neither come back as an event, nor
+	// keep the deletion of an unrelated file from being sent
+	key := storage.NewObjectKey(storage.NewKindKey(carGVK), runtime.NewIdentifier("default/a"))
+	if err := s.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	s.knownMux.Lock()
+	_, ok := s.known[key.String()]
+	s.knownMux.Unlock()
+	if ok {
+		t.Error("expected a to be removed from the known objects")
+	}
+
+	if err := os.Remove(other); err != nil {
+		t.Fatal(err)
+	}
+	if got := next(5 * time.Second); got != "DELETE c" {
+		t.Errorf("expected the event DELETE c, got %q", got)
+	}
+	if got := next(2 * time.Second); got != "" {
+		t.Errorf("expected no more events, got %q", got)
+	}
+}
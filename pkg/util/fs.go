@@ -1,7 +1,9 @@
 package util
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
 func PathExists(path string) (bool, os.FileInfo) {
@@ -21,3 +23,69 @@ func FileExists(filename string) bool {
 
 	return !info.IsDir()
 }
+
+// WriteFileAtomic writes data to filename in a crash-safe manner. The data is first written
+// to a temporary file in the same directory, which is synced to disk and then renamed to
+// filename. Readers will hence only ever see either the old or the new content in full.
+// If filename already exists, its permissions are kept, otherwise perm is used. If syncDir
+// is true, the parent directory is also synced after the rename, which makes sure the
+// new directory entry survives a crash.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode, syncDir bool) (err error) {
+	dir, base := filepath.Split(filename)
+	if len(dir) == 0 {
+		dir = "."
+	}
+
+	// Keep the permissions of the file we're replacing
+	if exists, info := PathExists(filename); exists {
+		perm = info.Mode().Perm()
+	}
+
+	// The temporary file is hidden and has a non-manifest extension,
+	// which makes sure e.g. file watchers don't pick it up
+	f, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	// Remove the temporary file if anything fails
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpName)
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+
+	if !syncDir {
+		return nil
+	}
+	return SyncDir(dir)
+}
+
+// SyncDir flushes the directory entries of the given directory to disk.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
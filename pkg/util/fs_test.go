@@ -0,0 +1,46 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "metadata.yaml")
+
+	if err := WriteFileAtomic(file, []byte("foo"), 0600, true); err != nil {
+		t.Fatal(err)
+	}
+	// Overwriting must keep the permissions of the original file
+	if err := WriteFileAtomic(file, []byte("bar"), 0644, false); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "bar" {
+		t.Errorf("expected content %q, got %q", "bar", content)
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("expected permissions %v, got %v", os.FileMode(0600), perm)
+	}
+
+	// No temporary files must be left behind
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected one file in %q, got %d", dir, len(entries))
+	}
+}
@@ -0,0 +1,105 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonPatchOp is an operation of a JSON patch (RFC 6902). It's a map so that the value is
+// only encoded for the operations having one, even if it's null.
+type jsonPatchOp map[string]interface{}
+
+// pointerEscaper escapes reference tokens of JSON pointers (RFC 6901)
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// createJSONPatch creates a JSON patch (RFC 6902) transforming the JSON document oldBytes into newBytes.
+// Changed objects are patched field by field, and lists element by element, adding or removing
+// elements at their end if their length changed.
+func createJSONPatch(oldBytes, newBytes []byte) ([]byte, error) {
+	old, err := decodeJSON(oldBytes)
+	if err != nil {
+		return nil, err
+	}
+	new, err := decodeJSON(newBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(diffJSON([]jsonPatchOp{}, "", old, new))
+}
+
+// decodeJSON decodes the given JSON document, keeping numbers as they are encoded
+func decodeJSON(b []byte) (interface{}, error) {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// diffJSON appends the operations transforming the old value at path into the new one to ops
+func diffJSON(ops []jsonPatchOp, path string, old, new interface{}) []jsonPatchOp {
+	switch o := old.(type) {
+	case map[string]interface{}:
+		if n, ok := new.(map[string]interface{}); ok {
+			return diffJSONObjects(ops, path, o, n)
+		}
+	case []interface{}:
+		if n, ok := new.([]interface{}); ok {
+			return diffJSONLists(ops, path, o, n)
+		}
+	}
+
+	if reflect.DeepEqual(old, new) {
+		return ops
+	}
+	return append(ops, jsonPatchOp{"op": "replace", "path": path, "value": new})
+}
+
+func diffJSONObjects(ops []jsonPatchOp, path string, old, new map[string]interface{}) []jsonPatchOp {
+	// Iterate the fields in a sorted order for the patch to be deterministic
+	for _, field := range sortedFields(old) {
+		fieldPath := path + "/" + pointerEscaper.Replace(field)
+		if value, ok := new[field]; ok {
+			ops = diffJSON(ops, fieldPath, old[field], value)
+		} else {
+			ops = append(ops, jsonPatchOp{"op": "remove", "path": fieldPath})
+		}
+	}
+	for _, field := range sortedFields(new) {
+		if _, ok := old[field]; !ok {
+			ops = append(ops, jsonPatchOp{"op": "add", "path": path + "/" + pointerEscaper.Replace(field), "value": new[field]})
+		}
+	}
+	return ops
+}
+
+func diffJSONLists(ops []jsonPatchOp, path string, old, new []interface{}) []jsonPatchOp {
+	i := 0
+	for ; i < len(old) && i < len(new); i++ {
+		ops = diffJSON(ops, path+"/"+strconv.Itoa(i), old[i], new[i])
+	}
+	for ; i < len(new); i++ {
+		ops = append(ops, jsonPatchOp{"op": "add", "path": path + "/" + strconv.Itoa(i), "value": new[i]})
+	}
+	// Remove the superfluous elements starting from the end, so that the indexes stay valid
+	for j := len(old) - 1; j >= len(new); j-- {
+		ops = append(ops, jsonPatchOp{"op": "remove", "path": path + "/" + strconv.Itoa(j)})
+	}
+	return ops
+}
+
+func sortedFields(m map[string]interface{}) []string {
+	fields := make([]string, 0, len(m))
+	for field := range m {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -5,16 +5,30 @@ import (
 	"fmt"
 	"io/ioutil"
 
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
 	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+	"github.com/save-abandoned-projects/libgitops/pkg/util"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
 )
 
+// Patcher creates and applies patches of the types supported by Kubernetes, i.e. JSON patches
+// (RFC 6902), JSON merge patches (RFC 7386) and strategic merge patches. Server-side apply
+// patches (types.ApplyPatchType) aren't supported, see Storage.Apply for those.
 type Patcher interface {
+	// Create creates a strategic merge patch out of the change made in applyFn
 	Create(new runtime.Object, applyFn func(runtime.Object) error) ([]byte, error)
-	Apply(original, patch []byte, gvk schema.GroupVersionKind) ([]byte, error)
-	ApplyOnFile(filePath string, patch []byte, gvk schema.GroupVersionKind) error
+	// CreateMergePatch creates a JSON merge patch out of the change made in applyFn
+	CreateMergePatch(new runtime.Object, applyFn func(runtime.Object) error) ([]byte, error)
+	// CreateJSONPatch creates a JSON patch out of the change made in applyFn
+	CreateJSONPatch(new runtime.Object, applyFn func(runtime.Object) error) ([]byte, error)
+	// Apply applies the given patch of the given type to the JSON-encoded original
+	Apply(original, patch []byte, patchType types.PatchType, gvk schema.GroupVersionKind) ([]byte, error)
+	// ApplyOnFile applies the given patch of the given type to the JSON-encoded file
+	ApplyOnFile(filePath string, patch []byte, patchType types.PatchType, gvk schema.GroupVersionKind) error
 }
 
 func NewPatcher(s serializer.Serializer) Patcher {
@@ -25,44 +39,74 @@ type patcher struct {
 	serializer serializer.Serializer
 }
 
-// Create is a helper that creates a patch out of the change made in applyFn
-func (p *patcher) Create(new runtime.Object, applyFn func(runtime.Object) error) (patchBytes []byte, err error) {
+// Create is a helper that creates a strategic merge patch out of the change made in applyFn
+func (p *patcher) Create(new runtime.Object, applyFn func(runtime.Object) error) ([]byte, error) {
+	emptyObj, err := p.serializer.Scheme().New(new.GetObjectKind().GroupVersionKind())
+	if err != nil {
+		return nil, err
+	}
+
+	return p.create(new, applyFn, func(oldBytes, newBytes []byte) ([]byte, error) {
+		patchBytes, err := strategicpatch.CreateTwoWayMergePatch(oldBytes, newBytes, emptyObj)
+		if err != nil {
+			return nil, fmt.Errorf("CreateTwoWayMergePatch failed: %v", err)
+		}
+		return patchBytes, nil
+	})
+}
+
+// CreateMergePatch is a helper that creates a JSON merge patch out of the change made in applyFn
+func (p *patcher) CreateMergePatch(new runtime.Object, applyFn func(runtime.Object) error) ([]byte, error) {
+	return p.create(new, applyFn, jsonpatch.CreateMergePatch)
+}
+
+// CreateJSONPatch is a helper that creates a JSON patch out of the change made in applyFn
+func (p *patcher) CreateJSONPatch(new runtime.Object, applyFn func(runtime.Object) error) ([]byte, error) {
+	return p.create(new, applyFn, createJSONPatch)
+}
+
+// create encodes new before and after applyFn changed it, and creates a patch out of the two using createFn
+func (p *patcher) create(new runtime.Object, applyFn func(runtime.Object) error, createFn func(oldBytes, newBytes []byte) ([]byte, error)) ([]byte, error) {
 	var oldBytes, newBytes bytes.Buffer
 	encoder := p.serializer.Encoder()
 	old := new.DeepCopyObject().(runtime.Object)
 
-	if err = encoder.Encode(serializer.NewJSONFrameWriter(&oldBytes), old); err != nil {
-		return
-	}
-
-	if err = applyFn(new); err != nil {
-		return
-	}
-
-	if err = encoder.Encode(serializer.NewJSONFrameWriter(&newBytes), new); err != nil {
-		return
+	if err := encoder.Encode(serializer.NewJSONFrameWriter(&oldBytes), old); err != nil {
+		return nil, err
 	}
 
-	emptyObj, err := p.serializer.Scheme().New(old.GetObjectKind().GroupVersionKind())
-	if err != nil {
-		return
+	if err := applyFn(new); err != nil {
+		return nil, err
 	}
 
-	patchBytes, err = strategicpatch.CreateTwoWayMergePatch(oldBytes.Bytes(), newBytes.Bytes(), emptyObj)
-	if err != nil {
-		return nil, fmt.Errorf("CreateTwoWayMergePatch failed: %v", err)
+	if err := encoder.Encode(serializer.NewJSONFrameWriter(&newBytes), new); err != nil {
+		return nil, err
 	}
 
-	return patchBytes, nil
+	return createFn(oldBytes.Bytes(), newBytes.Bytes())
 }
 
-func (p *patcher) Apply(original, patch []byte, gvk schema.GroupVersionKind) ([]byte, error) {
-	emptyObj, err := p.serializer.Scheme().New(gvk)
-	if err != nil {
-		return nil, err
+func (p *patcher) Apply(original, patch []byte, patchType types.PatchType, gvk schema.GroupVersionKind) ([]byte, error) {
+	var b []byte
+	var err error
+	switch patchType {
+	case types.JSONPatchType:
+		var jp jsonpatch.Patch
+		if jp, err = jsonpatch.DecodePatch(patch); err != nil {
+			return nil, err
+		}
+		b, err = jp.Apply(original)
+	case types.MergePatchType:
+		b, err = jsonpatch.MergePatch(original, patch)
+	case types.StrategicMergePatchType:
+		var emptyObj kruntime.Object
+		if emptyObj, err = p.serializer.Scheme().New(gvk); err != nil {
+			return nil, err
+		}
+		b, err = strategicpatch.StrategicMergePatch(original, patch, emptyObj)
+	default:
+		return nil, fmt.Errorf("unsupported patch type %q", patchType)
 	}
-
-	b, err := strategicpatch.StrategicMergePatch(original, patch, emptyObj)
 	if err != nil {
 		return nil, err
 	}
@@ -70,26 +114,27 @@ func (p *patcher) Apply(original, patch []byte, gvk schema.GroupVersionKind) ([]
 	return p.serializerEncode(b)
 }
 
-func (p *patcher) ApplyOnFile(filePath string, patch []byte, gvk schema.GroupVersionKind) error {
+func (p *patcher) ApplyOnFile(filePath string, patch []byte, patchType types.PatchType, gvk schema.GroupVersionKind) error {
 	oldContent, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		return err
 	}
 
-	newContent, err := p.Apply(oldContent, patch, gvk)
+	newContent, err := p.Apply(oldContent, patch, patchType, gvk)
 	if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(filePath, newContent, 0644)
+	return util.WriteFileAtomic(filePath, newContent, 0644, false)
 }
 
-// StrategicMergePatch returns an unindented, unorganized JSON byte slice,
+// The patches return an unindented, unorganized JSON byte slice,
 // this helper takes that as an input and returns the same JSON re-encoded
-// with the serializer so it conforms to a runtime.Object
+// with the serializer so it conforms to a runtime.Object. Kinds unknown
+// to the scheme are re-encoded as unstructured objects.
 // TODO: Just use encoding/json.Indent here instead?
 func (p *patcher) serializerEncode(input []byte) ([]byte, error) {
-	obj, err := p.serializer.Decoder().Decode(serializer.NewJSONFrameReader(serializer.FromBytes(input)))
+	obj, err := p.serializer.Decoder(serializer.WithUnstructuredDecode(true)).Decode(serializer.NewJSONFrameReader(serializer.FromBytes(input)))
 	if err != nil {
 		return nil, err
 	}
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"testing"
 
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	api "github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample"
 	"github.com/save-abandoned-projects/libgitops/cmd/sample-app/apis/sample/scheme"
 	"github.com/save-abandoned-projects/libgitops/pkg/runtime"
 	"github.com/save-abandoned-projects/libgitops/pkg/serializer"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 var (
@@ -49,7 +51,7 @@ func TestCreatePatch(t *testing.T) {
 }
 
 func TestApplyPatch(t *testing.T) {
-	result, err := p.Apply(basebytes, overlaybytes, carGVK)
+	result, err := p.Apply(basebytes, overlaybytes, types.StrategicMergePatchType, carGVK)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -58,3 +60,156 @@ func TestApplyPatch(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCreatePatchTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		createFn func(runtime.Object, func(runtime.Object) error) ([]byte, error)
+		want     string
+	}{
+		{
+			name:     "merge patch",
+			createFn: p.CreateMergePatch,
+			want:     `{"spec":{"brand":"baz"}}`,
+		},
+		{
+			name:     "JSON patch",
+			createFn: p.CreateJSONPatch,
+			want:     `[{"op":"replace","path":"/spec/brand","value":"baz"}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			car := &api.Car{Spec: api.CarSpec{Engine: "foo", Brand: "bar"}}
+			car.SetGroupVersionKind(carGVK)
+			b, err := tt.createFn(car, func(obj runtime.Object) error {
+				obj.(*api.Car).Spec.Brand = "baz"
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, b)
+			}
+		})
+	}
+}
+
+func TestApplyPatchTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		patchType types.PatchType
+		patch     string
+		wantBrand string
+		wantErr   bool
+	}{
+		{
+			name:      "JSON patch",
+			patchType: types.JSONPatchType,
+			patch:     `[{"op":"test","path":"/spec/brand","value":"bar"},{"op":"replace","path":"/spec/brand","value":"baz"}]`,
+			wantBrand: "baz",
+		},
+		{
+			name:      "failed JSON patch test",
+			patchType: types.JSONPatchType,
+			patch:     `[{"op":"test","path":"/spec/brand","value":"baz"},{"op":"replace","path":"/spec/brand","value":"baz"}]`,
+			wantErr:   true,
+		},
+		{
+			name:      "merge patch",
+			patchType: types.MergePatchType,
+			patch:     `{"spec":{"brand":"baz"}}`,
+			wantBrand: "baz",
+		},
+		{
+			name:      "apply patch",
+			patchType: types.ApplyPatchType,
+			patch:     `{"spec":{"brand":"baz"}}`,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := p.Apply(basebytes, []byte(tt.patch), tt.patchType, carGVK)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			car := &api.Car{}
+			if err := scheme.Serializer.Decoder().DecodeInto(serializer.NewJSONFrameReader(serializer.FromBytes(result)), car); err != nil {
+				t.Fatal(err)
+			}
+			if car.Spec.Brand != tt.wantBrand {
+				t.Errorf("expected brand %q, got %q", tt.wantBrand, car.Spec.Brand)
+			}
+		})
+	}
+}
+
+func TestCreateJSONPatch(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want string
+	}{
+		{
+			name: "no change",
+			old:  `{"a":1}`,
+			new:  `{"a":1}`,
+			want: `[]`,
+		},
+		{
+			name: "fields are added, removed and replaced",
+			old:  `{"a":{"b":1,"c":2},"d":"x"}`,
+			new:  `{"a":{"b":3,"e":null},"f":[]}`,
+			want: `[{"op":"replace","path":"/a/b","value":3},{"op":"remove","path":"/a/c"},{"op":"add","path":"/a/e","value":null},{"op":"remove","path":"/d"},{"op":"add","path":"/f","value":[]}]`,
+		},
+		{
+			name: "lists are patched by index",
+			old:  `{"a":[1,2,3],"b":[{"c":1}]}`,
+			new:  `{"a":[1],"b":[{"c":2},4]}`,
+			want: `[{"op":"remove","path":"/a/2"},{"op":"remove","path":"/a/1"},{"op":"replace","path":"/b/0/c","value":2},{"op":"add","path":"/b/1","value":4}]`,
+		},
+		{
+			name: "field names are escaped",
+			old:  `{"labels":{"app.kubernetes.io/name":"a","x~y":"b"}}`,
+			new:  `{"labels":{"app.kubernetes.io/name":"c"}}`,
+			want: `[{"op":"replace","path":"/labels/app.kubernetes.io~1name","value":"c"},{"op":"remove","path":"/labels/x~0y"}]`,
+		},
+		{
+			name: "changed types are replaced",
+			old:  `{"a":{"b":1}}`,
+			new:  `{"a":[1]}`,
+			want: `[{"op":"replace","path":"/a","value":[1]}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := createJSONPatch([]byte(tt.old), []byte(tt.new))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, b)
+			}
+
+			// The patch must transform the old document into the new one
+			jp, err := jsonpatch.DecodePatch(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := jp.Apply([]byte(tt.old))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !jsonpatch.Equal(result, []byte(tt.new)) {
+				t.Errorf("expected the patch to result in %s, got %s", tt.new, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package sync
+
+import (
+	"context"
+	"sync"
+)
+
+// ForEachOrdered calls process for the indices 0 to n-1 using up to the given amount of
+// concurrent workers, and calls emit with the results in the order of the indices. At most
+// workers results are processed ahead of the one being emitted. emit is always called from
+// the calling goroutine.
+//
+// If process or emit returns an error, or the context is cancelled, no more work is started
+// and the first error is returned after all running workers have exited. If workers is 1 or
+// less, everything runs sequentially in the calling goroutine.
+func ForEachOrdered[T any](ctx context.Context, n, workers int, process func(i int) (T, error), emit func(T) error) error {
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			v, err := process(i)
+			if err != nil {
+				return err
+			}
+			if err := emit(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	type result struct {
+		v   T
+		err error
+	}
+
+	workCtx, cancel := context.WithCancel(ctx)
+	wg := &sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// pending holds the channels of the results in order. Its buffer, together with the
+	// channel the consumer is waiting for, bounds the amount of concurrent work.
+	pending := make(chan chan result, workers-1)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(pending)
+
+		for i := 0; i < n; i++ {
+			ch := make(chan result, 1)
+			select {
+			case pending <- ch:
+			case <-workCtx.Done():
+				return
+			}
+
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				v, err := process(i)
+				ch <- result{v, err}
+			}(i)
+		}
+	}()
+
+	for ch := range pending {
+		r := <-ch
+		if r.err != nil {
+			return r.err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := emit(r.v); err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
@@ -0,0 +1,77 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestForEachOrdered(t *testing.T) {
+	errTest := errors.New("test")
+
+	tests := []struct {
+		name    string
+		workers int
+		failAt  int
+		want    []int
+		wantErr error
+	}{
+		{name: "sequential", workers: 1, failAt: -1, want: []int{0, 2, 4, 6, 8, 10, 12, 14, 16, 18}},
+		{name: "parallel", workers: 4, failAt: -1, want: []int{0, 2, 4, 6, 8, 10, 12, 14, 16, 18}},
+		{name: "sequential error", workers: 1, failAt: 3, want: []int{0, 2, 4}, wantErr: errTest},
+		{name: "parallel error", workers: 4, failAt: 3, want: []int{0, 2, 4}, wantErr: errTest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var running, maxRunning int32
+			var got []int
+			err := ForEachOrdered(context.Background(), 10, tt.workers, func(i int) (int, error) {
+				n := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					m := atomic.LoadInt32(&maxRunning)
+					if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+						break
+					}
+				}
+
+				// Make the later indices finish first
+				time.Sleep(time.Duration(10-i) * time.Millisecond)
+				if i == tt.failAt {
+					return 0, errTest
+				}
+				return i * 2, nil
+			}, func(v int) error {
+				got = append(got, v)
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+			if int(maxRunning) > tt.workers {
+				t.Errorf("expected at most %d concurrent workers, got %d", tt.workers, maxRunning)
+			}
+		})
+	}
+}
+
+func TestForEachOrdered_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	count := 0
+	err := ForEachOrdered(ctx, 100, 4, func(i int) (int, error) {
+		return i, nil
+	}, func(int) error {
+		count++
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || count != 1 {
+		t.Errorf("expected context.Canceled after one result, got %v after %d", err, count)
+	}
+}
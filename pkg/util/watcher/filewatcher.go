@@ -3,6 +3,7 @@ package watcher
 import (
 	"fmt"
 	"path"
+	gosync "sync"
 	"time"
 
 	"github.com/rjeczalik/notify"
@@ -116,6 +117,7 @@ type FileWatcher struct {
 	events       eventStream
 	updates      FileUpdateStream
 	suspendEvent FileEvent
+	suspendMux   gosync.Mutex
 	monitor      *sync.Monitor
 	dispatcher   *sync.Monitor
 	opts         Options
@@ -144,9 +146,8 @@ func (w *FileWatcher) monitorFunc() {
 			continue // Skip invalid files
 		}
 
-		updateEvent := convertEvent(event.Event())
-		if w.suspendEvent > 0 && updateEvent == w.suspendEvent {
-			w.suspendEvent = 0
+		updateEvent := suspendableEvent(event.Event())
+		if w.resumeEvent(updateEvent) {
 			log.Debugf("FileWatcher: Skipping suspended event %s for path: %q", updateEvent, event.Path())
 			continue // Skip the suspended event
 		}
@@ -210,9 +211,23 @@ func (w *FileWatcher) Close() {
 // Suspend enables a one-time suspend of the given event,
 // the FileWatcher will skip the given event once
 func (w *FileWatcher) Suspend(updateEvent FileEvent) {
+	w.suspendMux.Lock()
+	defer w.suspendMux.Unlock()
 	w.suspendEvent = updateEvent
 }
 
+// resumeEvent returns true if the given event is suspended, and lifts the suspension
+func (w *FileWatcher) resumeEvent(updateEvent FileEvent) bool {
+	w.suspendMux.Lock()
+	defer w.suspendMux.Unlock()
+	if w.suspendEvent == 0 || updateEvent != w.suspendEvent {
+		return false
+	}
+
+	w.suspendEvent = 0
+	return true
+}
+
 func convertEvent(event notify.Event) FileEvent {
 	if updateEvent, ok := eventMap[event]; ok {
 		return updateEvent
@@ -221,6 +236,17 @@ func convertEvent(event notify.Event) FileEvent {
 	return FileEventNone
 }
 
+// suspendableEvent converts the event like convertEvent, but also treats a file being
+// moved into place as a modification. Atomic writes replace the target file using a
+// rename, hence they show up as InMovedTo instead of InCloseWrite.
+func suspendableEvent(event notify.Event) FileEvent {
+	if event == notify.InMovedTo {
+		return FileEventModify
+	}
+
+	return convertEvent(event)
+}
+
 func convertUpdate(event notify.EventInfo) *FileUpdate {
 	fileEvent := convertEvent(event.Event())
 	if fileEvent == FileEventNone {